@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/sendgrid/chaos/adaptor/crudalerts"
+	"github.com/sendgrid/chaos/client"
+)
+
+const (
+	// UsageAlertType and UsageAlertThreshold are what crud alerts is given for every user, the percentage of credits used
+	UsageAlertType      = "usage_limit"
+	UsageAlertThreshold = 90
+)
+
+// usageNotifier is the crud alerts client along with the read back it only offers on the concrete type
+type usageNotifier interface {
+	crudalerts.CrudClient
+	GetUsageNotifications(signup client.SignupResponse) ([]crudalerts.AlertData, error)
+}
+
+// CrudAlerts registers usage notifications, it is nil unless -crudalerts is set
+var CrudAlerts usageNotifier
+
+// setupCrudAlerts creates the crud alerts client
+func setupCrudAlerts() error {
+	if Conf.CrudAlerts == "" {
+		return nil
+	}
+
+	host, port, err := hostPort(Conf.CrudAlerts)
+	if err != nil {
+		return err
+	}
+
+	CrudAlerts = crudalerts.NewCrudClient(host, port, Conf.CrudAlertsHealthcheckPort)
+	return nil
+}
+
+// addBounceManagement turns on bounce management the way signup does
+func addBounceManagement(userID int) error {
+	errs := ApidAdaptor.AddBounceManagement(client.Signup{UserID: userID})
+	if len(errs) > 0 {
+		return fmt.Errorf("unable to add bounce management: %s", joinErrors(errs))
+	}
+	return nil
+}
+
+// setUsageAlerts registers the usage notification and reads it back, crud alerts does not say when a write fails
+func setUsageAlerts(account *Account) error {
+	errs := CrudAlerts.SetUsageNotifications(client.Signup{UserID: account.UserID, Email: account.Email})
+	if len(errs) > 0 {
+		return errors.New(joinErrors(errs))
+	}
+
+	alerts, err := CrudAlerts.GetUsageNotifications(client.SignupResponse{UserID: account.UserID})
+	if err != nil {
+		return fmt.Errorf("unable to read back usage notifications: %s", err.Error())
+	}
+
+	for _, alert := range alerts {
+		if alert.Type == UsageAlertType && alert.EmailTo == account.Email && alert.Percentage == UsageAlertThreshold {
+			return nil
+		}
+	}
+	return fmt.Errorf("no %s alert at %d%% to %s, got %+v", UsageAlertType, UsageAlertThreshold, account.Email, alerts)
+}
+
+func joinErrors(errs []error) string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, ", ")
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/sendgrid/chaos/adaptor/gandalf"
+)
+
+// GandalfAdaptor checks generated users can log in, it is nil unless -gandalf is set
+var GandalfAdaptor *gandalf.Adaptor
+
+// setupGandalf creates the gandalf adaptor
+func setupGandalf() error {
+	if Conf.Gandalf == "" {
+		return nil
+	}
+
+	host, port, err := hostPort(Conf.Gandalf)
+	if err != nil {
+		return err
+	}
+
+	GandalfAdaptor = gandalf.New(host, port, Conf.GandalfHealthcheckPort)
+	return nil
+}
+
+// authenticate logs the account in with its password and swaps the signup token for one gandalf vouches for.
+// The token is cleared when any step fails so the manifest only hands out working tokens
+func authenticate(account *Account) error {
+	token, err := authorizationToken(account)
+	account.Token = token
+	return err
+}
+
+func authorizationToken(account *Account) (string, error) {
+	validation, err := GandalfAdaptor.ValidatePassword(account.Username, account.Password)
+	if err != nil {
+		return "", fmt.Errorf("unable to log in as %s: %s", account.Username, err.Error())
+	}
+	if validation.UserID != account.UserID {
+		return "", fmt.Errorf("logging in as %s gave user %d, want %d", account.Username, validation.UserID, account.UserID)
+	}
+
+	token, err := GandalfAdaptor.GetAuthorizationToken(account.UserID)
+	if err != nil {
+		return "", fmt.Errorf("unable to get a token: %s", err.Error())
+	}
+	if token == "" {
+		return "", fmt.Errorf("gandalf gave an empty token")
+	}
+
+	userID, err := GandalfAdaptor.ValidateToken(token)
+	if err != nil {
+		return "", fmt.Errorf("unable to validate token: %s", err.Error())
+	}
+	if userID != account.UserID {
+		return "", fmt.Errorf("token belongs to user %d, want %d", userID, account.UserID)
+	}
+
+	return token, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sendgrid/chaos/adaptor/boss"
+	"github.com/sendgrid/chaos/client"
+)
+
+const (
+	// DefaultPaymentMethodID is what boss is told the user pays with when the template does not say
+	DefaultPaymentMethodID = "user_generator_card"
+	bossURLCacheTTL        = 10 * time.Minute
+)
+
+// BossAdaptor subscribes paid accounts, it is nil unless -boss or -fake-boss is set
+var BossAdaptor boss.Boss
+
+// BillingOptions makes the accounts of a template paid accounts by subscribing them through boss
+type BillingOptions struct {
+	PackageID       int      `json:"package_id"`
+	PaymentMethodID string   `json:"payment_method_id"`
+	AddOns          []string `json:"add_ons"`
+	// Coupon is the code of a coupon applied when subscribing, it is looked up when the scenario is loaded
+	Coupon string `json:"coupon"`
+	// ChangePackageID moves the account to another package after subscribing,
+	// at the start of next month unless ChangeImmediately is set
+	ChangePackageID   int  `json:"change_package_id"`
+	ChangeImmediately bool `json:"change_immediately"`
+
+	coupon *client.Coupon
+}
+
+func (b *BillingOptions) validate() error {
+	if b.PackageID < 1 {
+		return errors.New("billing needs a package_id")
+	}
+	if b.ChangePackageID < 0 {
+		return errors.New("billing change_package_id can not be negative")
+	}
+	if b.PaymentMethodID == "" {
+		b.PaymentMethodID = DefaultPaymentMethodID
+	}
+	if Conf.Boss == "" {
+		return errors.New("billing needs -boss or -fake-boss")
+	}
+	// the coupon code ends up in a json where clause
+	if strings.ContainsAny(b.Coupon, `"\`) {
+		return fmt.Errorf("coupon %q can not contain quotes or backslashes", b.Coupon)
+	}
+	return nil
+}
+
+// lookupCoupon finds the template's coupon so unknown and expired codes are rejected before any account is made
+func (b *BillingOptions) lookupCoupon() error {
+	if b.Coupon == "" {
+		return nil
+	}
+
+	coupon, err := findCoupon(b.Coupon)
+	if err != nil {
+		return err
+	}
+
+	b.coupon = coupon
+	return nil
+}
+
+// findCoupon looks up a coupon by code, it is an error for it to be missing or invalid
+func findCoupon(code string) (*client.Coupon, error) {
+	coupon, adaptorErr := ApidAdaptor.CouponInfo(code)
+	if adaptorErr != nil {
+		return nil, fmt.Errorf("unable to find coupon %s: %s", code, adaptorErr.Error())
+	}
+	if coupon.CouponCode == "" {
+		return nil, fmt.Errorf("unknown coupon %s", code)
+	}
+	if !coupon.Valid {
+		return nil, fmt.Errorf("coupon %s is not valid", code)
+	}
+	return &coupon, nil
+}
+
+// setupBoss creates the boss adaptor, which fetches the billing provider urls straight away
+func setupBoss() {
+	if Conf.Boss == "" {
+		return
+	}
+
+	BossAdaptor = boss.New(Conf.Boss, Conf.BossToken, nil, bossURLCacheTTL)
+}
+
+// subscribe puts the user on the paid package through boss, the way a real upgrade does
+func subscribe(userID int, billing *BillingOptions) error {
+	err := BossAdaptor.Subscribe(&boss.BossSubscriptionParams{
+		AccountID:       userID,
+		PackageID:       billing.PackageID,
+		PaymentMethodID: billing.PaymentMethodID,
+		AddOns:          billing.AddOns,
+		Coupon:          billing.coupon,
+	})
+	if err != nil {
+		if billing.coupon != nil {
+			return fmt.Errorf("unable to subscribe to package %d with coupon %s: %s", billing.PackageID, billing.Coupon, err.Error())
+		}
+		return fmt.Errorf("unable to subscribe to package %d: %s", billing.PackageID, err.Error())
+	}
+
+	if billing.ChangePackageID == 0 {
+		return nil
+	}
+
+	adaptorErr := BossAdaptor.ChangePackage(userID, billing.ChangePackageID, billing.ChangeImmediately, billing.AddOns...)
+	if adaptorErr != nil {
+		return fmt.Errorf("unable to change to package %d: %s", billing.ChangePackageID, adaptorErr.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/sendgrid/chaos/adaptor"
+)
+
+const (
+	// cleanupBatchSize keeps the bulk apid calls to a reasonable url length
+	cleanupBatchSize = 100
+)
+
+// validPrefix keeps the username prefix safe to quote in sql, _ is escaped before it goes in a like clause
+var validPrefix = regexp.MustCompile(`^[A-Za-z0-9_.@-]+$`)
+
+// likeEscaper escapes the like wildcards so the prefix matches literally
+var likeEscaper = strings.NewReplacer(`_`, `\_`, `%`, `\%`)
+
+// CleanupUser is a parent user picked for deletion
+type CleanupUser struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+}
+
+// CleanupReport counts what each cleanup operation removed
+type CleanupReport struct {
+	Parents  []CleanupUser
+	Subusers []int
+	Counts   map[string]int
+	Errors   []error
+}
+
+func NewCleanupReport() *CleanupReport {
+	return &CleanupReport{
+		Parents:  make([]CleanupUser, 0),
+		Subusers: make([]int, 0),
+		Counts:   make(map[string]int),
+		Errors:   make([]error, 0),
+	}
+}
+
+func (r *CleanupReport) record(operation string, count int, adaptorErr *adaptor.AdaptorError) {
+	r.Counts[operation] += count
+	if adaptorErr != nil {
+		r.Errors = append(r.Errors, fmt.Errorf("%s: %s", operation, adaptorErr.Error()))
+	}
+}
+
+// runCleanup deletes the accounts from a manifest or with a username prefix and releases their ips
+func runCleanup(args []string) int {
+	fs := flag.NewFlagSet("cleanup", flag.ExitOnError)
+	manifestPath := fs.String("manifest", "", "json manifest written by a previous run")
+	prefix := fs.String("prefix", "", "delete every user whose username starts with this prefix, e.g. testuser_")
+	dryRun := fs.Bool("dry-run", false, "only report the users that would be deleted")
+	confirm := fs.Bool("confirm", false, "delete the users, without it cleanup only lists them")
+	registerConfigFlags(fs)
+	err := parseFlags(fs, args)
+	if err != nil {
+		fmt.Printf("oh no there's an error loading the config! %s\n", err.Error())
+		return 2
+	}
+
+	if (*manifestPath == "") == (*prefix == "") {
+		fmt.Println("cleanup needs exactly one of -manifest or -prefix")
+		return 2
+	}
+
+	setupClients()
+
+	var parents []CleanupUser
+	if *manifestPath != "" {
+		parents, err = parentsFromManifest(*manifestPath)
+	} else {
+		parents, err = parentsWithPrefix(*prefix)
+	}
+	if err != nil {
+		fmt.Printf("oh no there's an error finding users to delete! %s\n", err.Error())
+		return 1
+	}
+
+	report := cleanup(parents, *dryRun || !*confirm)
+	report.Print()
+
+	if len(report.Errors) > 0 {
+		return 1
+	}
+	if !*dryRun && !*confirm {
+		fmt.Println("nothing was deleted, rerun with -confirm to delete these users")
+		return 2
+	}
+	return 0
+}
+
+// parentsFromManifest returns the parent users in a manifest, subusers are found through their reseller
+func parentsFromManifest(path string) ([]CleanupUser, error) {
+	manifest, err := readManifest(path)
+	if err != nil {
+		return nil, err
+	}
+
+	parents := make([]CleanupUser, 0)
+	for _, a := range manifest.Accounts {
+		if a.ResellerID == 0 && a.UserID != 0 {
+			parents = append(parents, CleanupUser{ID: a.UserID, Username: a.Username})
+		}
+	}
+
+	return parents, nil
+}
+
+// parentsWithPrefix finds the parent users whose username starts with prefix
+func parentsWithPrefix(prefix string) ([]CleanupUser, error) {
+	query, err := prefixQuery(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var users []CleanupUser
+	err = ApidClient.DoFunction("executeSql", url.Values{
+		"query":    []string{query},
+		"rw":       []string{"0"},
+		"resource": []string{"mail"},
+	}, &users)
+	if err != nil {
+		return nil, err
+	}
+
+	return withPrefix(users, prefix), nil
+}
+
+// prefixQuery selects the parent users whose username is like the prefix
+func prefixQuery(prefix string) (string, error) {
+	if !validPrefix.MatchString(prefix) {
+		return "", fmt.Errorf("invalid prefix %q", prefix)
+	}
+	return fmt.Sprintf(`select id, username from user where username like "%s%%" escape '\\' and reseller_id = 0`, likeEscaper.Replace(prefix)), nil
+}
+
+// withPrefix keeps the users that really start with prefix,
+// the like clause is only a first pass and a case insensitive collation matches more than the prefix
+func withPrefix(users []CleanupUser, prefix string) []CleanupUser {
+	parents := make([]CleanupUser, 0, len(users))
+	for _, u := range users {
+		if strings.HasPrefix(u.Username, prefix) {
+			parents = append(parents, u)
+		}
+	}
+	return parents
+}
+
+// cleanup releases the ips of the parents and their subusers and soft deletes all of them
+func cleanup(parents []CleanupUser, dryRun bool) *CleanupReport {
+	report := NewCleanupReport()
+	report.Parents = parents
+
+	parentIDs := make([]int, len(parents))
+	for i, parent := range parents {
+		parentIDs[i] = parent.ID
+		subuserIDs, adaptorErr := ApidAdaptor.GetSubuserIDs(parent.ID)
+		if adaptorErr != nil {
+			report.record("get subusers", 0, adaptorErr)
+			continue
+		}
+		report.Subusers = append(report.Subusers, subuserIDs...)
+	}
+
+	if dryRun {
+		return report
+	}
+
+	userIDs := append(append([]int{}, parentIDs...), report.Subusers...)
+	for start := 0; start < len(userIDs); start += cleanupBatchSize {
+		end := start + cleanupBatchSize
+		if end > len(userIDs) {
+			end = len(userIDs)
+		}
+		batch := userIDs[start:end]
+
+		count, adaptorErr := ApidAdaptor.UnassignExternalIps(batch)
+		report.record("unassign external ips", count, adaptorErr)
+
+		count, adaptorErr = ApidAdaptor.DeleteAllUserIps(batch)
+		report.record("delete user ips", count, adaptorErr)
+
+		count, adaptorErr = ApidAdaptor.DeleteAllUserIpGroups(batch)
+		report.record("delete user ip groups", count, adaptorErr)
+
+		count, adaptorErr = ApidAdaptor.DeleteUserAlerts(batch)
+		report.record("delete user alerts", count, adaptorErr)
+
+		count, adaptorErr = ApidAdaptor.DeleteAllUserNotificationSettings(batch)
+		report.record("delete notification settings", count, adaptorErr)
+	}
+
+	for _, userID := range userIDs {
+		count, adaptorErr := ApidAdaptor.DeleteCreditLimits(userID)
+		report.record("delete credit limits", count, adaptorErr)
+	}
+
+	for _, parentID := range parentIDs {
+		count, adaptorErr := ApidAdaptor.SoftDeleteSubusers(parentID)
+		report.record("soft delete subusers", count, adaptorErr)
+
+		count, adaptorErr = ApidAdaptor.SoftDeleteUser(parentID)
+		report.record("soft delete users", count, adaptorErr)
+	}
+
+	return report
+}
+
+// Print writes a summary of the cleanup to stdout
+func (r *CleanupReport) Print() {
+	fmt.Printf("found %d users and %d subusers\n", len(r.Parents), len(r.Subusers))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, parent := range r.Parents {
+		fmt.Fprintf(w, "%d\t%s\n", parent.ID, parent.Username)
+	}
+	w.Flush()
+
+	for _, operation := range cleanupOperations {
+		if count, ok := r.Counts[operation]; ok {
+			fmt.Fprintf(w, "%s\t%d\n", operation, count)
+		}
+	}
+	w.Flush()
+
+	for _, err := range r.Errors {
+		fmt.Printf("oh no there's an error! %s\n", err.Error())
+	}
+}
+
+// cleanupOperations is the order operations are reported in
+var cleanupOperations = []string{
+	"unassign external ips",
+	"delete user ips",
+	"delete user ip groups",
+	"delete user alerts",
+	"delete notification settings",
+	"delete credit limits",
+	"soft delete subusers",
+	"soft delete users",
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestPrefixQuery(t *testing.T) {
+	tests := []struct {
+		prefix string
+		query  string
+		valid  bool
+	}{
+		{"testuser_", `select id, username from user where username like "testuser\_%" escape '\\' and reseller_id = 0`, true},
+		{"test_user_1", `select id, username from user where username like "test\_user\_1%" escape '\\' and reseller_id = 0`, true},
+		{"qa.load-test@", `select id, username from user where username like "qa.load-test@%" escape '\\' and reseller_id = 0`, true},
+		{"", "", false},
+		{"%", "", false},
+		{"testuser%", "", false},
+		{`testuser\`, "", false},
+		{`testuser"`, "", false},
+		{`testuser'`, "", false},
+		{`" or 1=1 or "`, "", false},
+	}
+
+	for _, test := range tests {
+		query, err := prefixQuery(test.prefix)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("prefix %q should be rejected, got query %s", test.prefix, query)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("prefix %q should be accepted, got %s", test.prefix, err.Error())
+			continue
+		}
+		if query != test.query {
+			t.Errorf("got query %s for prefix %q, want %s", query, test.prefix, test.query)
+		}
+	}
+}
+
+func TestLikeEscaper(t *testing.T) {
+	tests := map[string]string{
+		"testuser_":  `testuser\_`,
+		"100%":       `100\%`,
+		"_%_":        `\_\%\_`,
+		"plain.name": "plain.name",
+	}
+
+	for prefix, want := range tests {
+		if got := likeEscaper.Replace(prefix); got != want {
+			t.Errorf("got %s escaping %q, want %s", got, prefix, want)
+		}
+	}
+}
+
+func TestWithPrefix(t *testing.T) {
+	users := []CleanupUser{
+		{ID: 1, Username: "testuser_1"},
+		{ID: 2, Username: "TESTUSER_2"},
+		{ID: 3, Username: "testuserx3"},
+		{ID: 4, Username: "testuser_"},
+		{ID: 5, Username: "other_testuser_5"},
+	}
+
+	parents := withPrefix(users, "testuser_")
+	if len(parents) != 2 || parents[0].ID != 1 || parents[1].ID != 4 {
+		t.Errorf("got %v, want users 1 and 4", parents)
+	}
+}
+
+func TestSimulateCleanup(t *testing.T) {
+	TotalUsers, SubusersPerUser = 2, 2
+	startSimulation(t)
+	simulate(t, defaultScenario())
+
+	parents, err := parentsWithPrefix("testuser_" + RunID + "_")
+	if err != nil {
+		t.Fatalf("unable to find users: %s", err.Error())
+	}
+	if len(parents) != TotalUsers {
+		t.Fatalf("got %d users, want %d", len(parents), TotalUsers)
+	}
+
+	report := cleanup(parents, true)
+	if len(report.Subusers) != TotalUsers*SubusersPerUser {
+		t.Errorf("dry run found %d subusers, want %d", len(report.Subusers), TotalUsers*SubusersPerUser)
+	}
+	if len(report.Counts) != 0 {
+		t.Errorf("dry run should not change anything, got %v", report.Counts)
+	}
+
+	report = cleanup(parents, false)
+	for _, err := range report.Errors {
+		t.Errorf("cleanup failed: %s", err.Error())
+	}
+	if report.Counts["soft delete users"] != TotalUsers {
+		t.Errorf("soft deleted %d users, want %d", report.Counts["soft delete users"], TotalUsers)
+	}
+	if report.Counts["soft delete subusers"] != TotalUsers*SubusersPerUser {
+		t.Errorf("soft deleted %d subusers, want %d", report.Counts["soft delete subusers"], TotalUsers*SubusersPerUser)
+	}
+	// every subuser claims an ip of its own from its reseller
+	if want := TotalUsers * (SubusersPerUser + 1); report.Counts["unassign external ips"] != want {
+		t.Errorf("unassigned %d ips, want %d", report.Counts["unassign external ips"], want)
+	}
+
+	for _, parent := range parents {
+		ips, adaptorErr := ApidAdaptor.GetUserSendIps(parent.ID)
+		if adaptorErr != nil {
+			t.Fatalf("unable to get send ips: %s", adaptorErr.Error())
+		}
+		if len(ips) != 0 {
+			t.Errorf("user %d still sends from %v", parent.ID, ips)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sendgrid/go-envy"
+)
+
+// EnvPrefix is the prefix of the environment variables that configure the generator
+const EnvPrefix = "USER_GENERATOR_"
+
+// Config is everything needed to reach the services the generator talks to.
+// Each field is set by a flag, by the matching USER_GENERATOR_ environment variable,
+// or by the flag default, in that order
+type Config struct {
+	Chaos     string `name:"CHAOS" example:"http://localhost:50110"`
+	ChaosAuth string `name:"CHAOS_AUTH" example:"token abc123"`
+
+	Apid     string `name:"APID" example:"http://localhost:8082"`
+	ApidAuth string `name:"APID_AUTH" example:"token abc123"`
+
+	Gandalf                string `name:"GANDALF" example:"http://localhost:50130"`
+	GandalfAuth            string `name:"GANDALF_AUTH" example:"token abc123"`
+	GandalfHealthcheckPort int    `name:"GANDALF_HEALTHCHECK_PORT" example:"50131"`
+
+	Authzd                string `name:"AUTHZD" example:"http://localhost:50140"`
+	AuthzdAuth            string `name:"AUTHZD_AUTH" example:"token abc123"`
+	AuthzdHealthcheckPort int    `name:"AUTHZD_HEALTHCHECK_PORT" example:"50141"`
+
+	CrudAlerts                string `name:"CRUDALERTS" example:"http://localhost:50150"`
+	CrudAlertsHealthcheckPort int    `name:"CRUDALERTS_HEALTHCHECK_PORT" example:"50151"`
+
+	Boss      string `name:"BOSS" example:"http://localhost:8080"`
+	BossToken string `name:"BOSS_TOKEN" example:"abc123"`
+	FakeBoss  bool   `name:"FAKE_BOSS" example:"true"`
+
+	// Simulate replaces chaos, apid and boss with in-process fakes, the urls for them are ignored.
+	// There is no fake of crud alerts, authzd or the mail api, scenarios/simulate.json leaves out what needs them
+	Simulate bool `name:"SIMULATE" example:"true"`
+
+	// MailSink is where confirmation emails are captured, chaos's mail api has to point at it
+	MailSink string `name:"MAIL_SINK" example:"localhost:50190"`
+
+	Timeout int `name:"TIMEOUT" example:"30"`
+	RPS     int `name:"RPS" example:"20"`
+}
+
+// Conf is the configuration shared by every command
+var Conf Config
+
+// registerConfigFlags adds the flags every command needs to reach chaos, apid and the other services
+func registerConfigFlags(fs *flag.FlagSet) {
+	fs.StringVar(&Conf.Chaos, "chaos", "http://localhost:50110", "chaos base url")
+	fs.StringVar(&Conf.ChaosAuth, "chaos-auth", "", "Authorization header sent to chaos")
+	fs.StringVar(&Conf.Apid, "apid", "http://localhost:8082", "apid base url")
+	fs.StringVar(&Conf.ApidAuth, "apid-auth", "", "Authorization header sent to apid")
+	fs.StringVar(&Conf.Gandalf, "gandalf", "", "gandalf base url, checks are skipped when empty")
+	fs.StringVar(&Conf.GandalfAuth, "gandalf-auth", "", "Authorization header sent to gandalf")
+	fs.IntVar(&Conf.GandalfHealthcheckPort, "gandalf-healthcheck-port", 50131, "gandalf healthcheck port")
+	fs.StringVar(&Conf.Authzd, "authzd", "", "authzd base url, checks are skipped when empty")
+	fs.StringVar(&Conf.AuthzdAuth, "authzd-auth", "", "Authorization header sent to authzd")
+	fs.IntVar(&Conf.AuthzdHealthcheckPort, "authzd-healthcheck-port", 50141, "authzd healthcheck port")
+	fs.StringVar(&Conf.CrudAlerts, "crudalerts", "", "crud alerts base url, checks are skipped when empty")
+	fs.IntVar(&Conf.CrudAlertsHealthcheckPort, "crudalerts-healthcheck-port", 50151, "crud alerts healthcheck port")
+	fs.StringVar(&Conf.Boss, "boss", "", "boss base url, checks are skipped when empty")
+	fs.StringVar(&Conf.BossToken, "boss-token", "", "boss auth token")
+	fs.BoolVar(&Conf.FakeBoss, "fake-boss", false, "run an in-process fake boss instead of using -boss")
+	fs.BoolVar(&Conf.Simulate, "simulate", false, "run against in-process fakes of chaos, apid and boss instead, nothing they store outlives the run")
+	fs.StringVar(&Conf.MailSink, "mail-sink", "", "address to capture confirmation emails on, chaos's mail api has to point at it")
+	fs.IntVar(&Conf.Timeout, "timeout", 30, "timeout in seconds for every request")
+	fs.IntVar(&Conf.RPS, "rps", 20, "max requests per second to chaos and apid combined, 0 for unlimited")
+}
+
+// parseFlags loads the environment on top of the flag defaults and then parses the command line on top of that
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	defaults := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		defaults[envKey(f.Name)] = f.DefValue
+	})
+
+	reader := &defaultingReader{
+		EnvironmentReader: &envy.OsEnvironmentReader{Prefix: EnvPrefix},
+		defaults:          defaults,
+	}
+	err := envy.LoadFromEnv(reader, &Conf, log.New(os.Stderr, "[config] ", 0))
+	if err != nil {
+		return err
+	}
+
+	err = fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	Conf.Chaos = normalizeURL(Conf.Chaos, 50110)
+	Conf.Apid = normalizeURL(Conf.Apid, 8082)
+	Conf.Gandalf = normalizeURL(Conf.Gandalf, 50130)
+	Conf.Authzd = normalizeURL(Conf.Authzd, 50140)
+	Conf.CrudAlerts = normalizeURL(Conf.CrudAlerts, 50150)
+	Conf.Boss = normalizeURL(Conf.Boss, 80)
+
+	// their adaptors take a host and port and only speak http
+	for _, service := range []struct{ flag, url string }{
+		{"gandalf", Conf.Gandalf},
+		{"authzd", Conf.Authzd},
+		{"crudalerts", Conf.CrudAlerts},
+	} {
+		if service.url != "" && !strings.HasPrefix(service.url, "http://") {
+			return fmt.Errorf("-%s has to be an http url, got %s", service.flag, service.url)
+		}
+	}
+	return nil
+}
+
+// envKey is the environment variable, without the prefix, for a flag
+func envKey(flagName string) string {
+	return strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// defaultingReader fills in the flag defaults for anything missing from the environment
+type defaultingReader struct {
+	envy.EnvironmentReader
+	defaults map[string]string
+}
+
+func (r *defaultingReader) Read() map[string]string {
+	values := r.EnvironmentReader.Read()
+	for k, v := range r.defaults {
+		if _, ok := values[k]; !ok {
+			values[k] = v
+		}
+	}
+	return values
+}
+
+// normalizeURL turns a bare host, which older versions of the flags took, into a base url.
+// Empty stays empty so optional services are still skipped
+func normalizeURL(raw string, defaultPort int) string {
+	if raw == "" || strings.Contains(raw, "://") {
+		return strings.TrimRight(raw, "/")
+	}
+	if !strings.Contains(raw, ":") {
+		raw = fmt.Sprintf("%s:%d", raw, defaultPort)
+	}
+	return "http://" + raw
+}
+
+// hostPort splits a base url into the host and port the chaos adaptors take,
+// parseFlags only lets http urls through for them so a url without a port is on 80
+func hostPort(baseURL string) (string, int, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", 0, err
+	}
+
+	host, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		return u.Host, 80, nil
+	}
+
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port in %s", baseURL)
+	}
+
+	return host, p, nil
+}
+
+// newTransport makes a transport that gives up on connecting and on waiting for headers after timeout
+func newTransport(timeout time.Duration) *http.Transport {
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		Dial:                  (&net.Dialer{Timeout: timeout}).Dial,
+		ResponseHeaderTimeout: timeout,
+		MaxIdleConnsPerHost:   Concurrency + 1,
+	}
+}
+
+// authTransport adds the configured Authorization header to requests for each service it was given with add
+type authTransport struct {
+	base    http.RoundTripper
+	headers map[string]string
+}
+
+func newAuthTransport(base http.RoundTripper) *authTransport {
+	return &authTransport{base: base, headers: make(map[string]string)}
+}
+
+func (t *authTransport) add(baseURL string, header string) {
+	if baseURL == "" || header == "" {
+		return
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return
+	}
+	t.headers[u.Host] = header
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	header, ok := t.headers[req.URL.Host]
+	if ok && req.Header.Get("Authorization") == "" {
+		// round trippers should not modify the request they are given
+		r := new(http.Request)
+		*r = *req
+		r.Header = make(http.Header, len(req.Header)+1)
+		for k, v := range req.Header {
+			r.Header[k] = v
+		}
+		r.Header.Set("Authorization", header)
+		req = r
+	}
+
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"testing"
+)
+
+func TestNormalizeURL(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"", ""},
+		{"localhost", "http://localhost:8082"},
+		{"localhost:9000", "http://localhost:9000"},
+		{"http://apid.example.com:8082/", "http://apid.example.com:8082"},
+		{"https://apid.example.com", "https://apid.example.com"},
+	}
+
+	for _, test := range tests {
+		if got := normalizeURL(test.raw, 8082); got != test.want {
+			t.Errorf("got %q for %q, want %q", got, test.raw, test.want)
+		}
+	}
+}
+
+func TestHostPort(t *testing.T) {
+	tests := []struct {
+		url  string
+		host string
+		port int
+	}{
+		{"http://localhost:50130", "localhost", 50130},
+		{"http://gandalf.example.com", "gandalf.example.com", 80},
+		{"http://10.0.0.1:50140", "10.0.0.1", 50140},
+	}
+
+	for _, test := range tests {
+		host, port, err := hostPort(test.url)
+		if err != nil {
+			t.Errorf("unable to split %s: %s", test.url, err.Error())
+			continue
+		}
+		if host != test.host || port != test.port {
+			t.Errorf("got %s and %d for %s, want %s and %d", host, port, test.url, test.host, test.port)
+		}
+	}
+
+	_, _, err := hostPort("http://localhost:port")
+	if err == nil {
+		t.Error("a port that is not a number should be rejected")
+	}
+}
+
+func TestParseFlagsURLs(t *testing.T) {
+	parse := func(args ...string) error {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		registerConfigFlags(fs)
+		return parseFlags(fs, args)
+	}
+
+	err := parse("-apid", "apid.example.com", "-gandalf", "gandalf.example.com")
+	if err != nil {
+		t.Fatalf("unable to parse flags: %s", err.Error())
+	}
+	if Conf.Apid != "http://apid.example.com:8082" || Conf.Gandalf != "http://gandalf.example.com:50130" {
+		t.Errorf("got apid %s and gandalf %s, want the default ports filled in", Conf.Apid, Conf.Gandalf)
+	}
+
+	err = parse("-chaos", "https://chaos.example.com")
+	if err != nil {
+		t.Errorf("chaos is called with its url and should take https, got %s", err.Error())
+	}
+
+	for _, f := range []string{"-gandalf", "-authzd", "-crudalerts"} {
+		if parse(f, "https://example.com") == nil {
+			t.Errorf("%s should not take an https url", f)
+		}
+	}
+}
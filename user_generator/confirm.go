@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sendgrid/chaos/client"
+	"github.com/sethgrid/httpsink"
+)
+
+// the chaos endpoints the signup pages call to confirm an email
+const (
+	sendConfirmationPath     = "/v1/signup/send_confirmation"
+	validateConfirmationPath = "/v1/signup/validate_confirmation"
+	accountConfirmationPath  = "/v1/signup/confirm_account"
+)
+
+// MailSinkCapacity is how many emails the sink takes before it turns them away like a full mail api
+const MailSinkCapacity = 1000000
+
+// confirmationToken finds the token in the link of a confirmation email
+var confirmationToken = regexp.MustCompile(`[?&]token=([A-Za-z0-9._~-]+)`)
+
+// ConfirmationSink captures the emails chaos sends so their confirmation tokens can be submitted.
+// It listens where chaos's mail api is pointed, see -mail-sink, and passes every email on to an httpsink
+// that answers it and keeps it
+type ConfirmationSink struct {
+	*httpsink.HTTPSink
+
+	listener net.Listener
+	proxy    *httputil.ReverseProxy
+
+	mutex   sync.Mutex
+	waiting map[string]chan string
+}
+
+// MailSink is nil unless -mail-sink is set
+var MailSink *ConfirmationSink
+
+// startConfirmationSink listens on addr and answers every email like the mail api does
+func startConfirmationSink(addr string) (*ConfirmationSink, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	sink, err := httpsink.NewHTTPSinkOnAddr("localhost:0", MailSinkCapacity)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	sink.SetResponse(&httpsink.SimpleResponseWriter{
+		Header:     map[string]string{"Content-Type": "application/json"},
+		StatusCode: http.StatusOK,
+		Body:       []byte(`{"message":"success"}`),
+	})
+	go sink.StartHTTP()
+
+	s := &ConfirmationSink{
+		HTTPSink: sink,
+		listener: listener,
+		proxy:    httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: sink.Addr}),
+		waiting:  make(map[string]chan string),
+	}
+	// httpsink keeps the requests but not their bodies, so the tokens are read on the way in
+	go http.Serve(listener, http.HandlerFunc(s.capture))
+	return s, nil
+}
+
+// capture delivers the token in the email and hands the email on to the sink
+func (s *ConfirmationSink) capture(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	s.deliver(r.Header.Get("Content-Type"), body)
+	s.proxy.ServeHTTP(w, r)
+}
+
+// deliver hands the token in the email to whoever is waiting on one of its recipients
+func (s *ConfirmationSink) deliver(contentType string, body []byte) {
+	// form posts escape the link, json ones do not
+	email := string(body)
+	if unescaped, err := url.QueryUnescape(email); err == nil {
+		email = unescaped
+	}
+	match := confirmationToken.FindStringSubmatch(email)
+	if match == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, recipient := range emailRecipients(contentType, body) {
+		if token, ok := s.waiting[recipient]; ok {
+			token <- match[1]
+			delete(s.waiting, recipient)
+			return
+		}
+	}
+}
+
+// emailRecipients returns the addresses in the to of a mail api form post or a json email
+func emailRecipients(contentType string, body []byte) []string {
+	var to []string
+	if strings.HasPrefix(contentType, "application/json") {
+		var email struct {
+			To json.RawMessage `json:"to"`
+		}
+		err := json.Unmarshal(body, &email)
+		if err != nil {
+			return nil
+		}
+		// to is a single address or a list of them
+		var address string
+		if json.Unmarshal(email.To, &address) == nil {
+			to = []string{address}
+		} else {
+			json.Unmarshal(email.To, &to)
+		}
+	} else {
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil
+		}
+		to = append(to, form["to"]...)
+		to = append(to, form["to[]"]...)
+	}
+
+	addresses := make([]string, 0, len(to))
+	for _, t := range to {
+		address, err := mail.ParseAddress(t)
+		if err == nil {
+			addresses = append(addresses, address.Address)
+		}
+	}
+	return addresses
+}
+
+// expect registers interest in the next confirmation sent to address, call it before the email is triggered
+func (s *ConfirmationSink) expect(address string) chan string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	token := make(chan string, 1)
+	s.waiting[address] = token
+	return token
+}
+
+func (s *ConfirmationSink) forget(address string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.waiting, address)
+}
+
+// confirmEmail activates the account the way a user does: chaos emails a link, the token in it is validated,
+// and the account is confirmed with the signup session
+func confirmEmail(account *Account, t AccountTemplate) error {
+	token := MailSink.expect(account.Email)
+	defer MailSink.forget(account.Email)
+
+	err := postChaos(sendConfirmationPath, account.Token, client.SendConfirmation{Email: account.Email}, http.StatusOK)
+	if err != nil {
+		return fmt.Errorf("unable to send confirmation: %s", err.Error())
+	}
+
+	var confirmation string
+	select {
+	case confirmation = <-token:
+	case <-time.After(time.Duration(Conf.Timeout) * time.Second):
+		return fmt.Errorf("no confirmation email to %s reached the mail sink within %ds", account.Email, Conf.Timeout)
+	}
+
+	err = postChaos(validateConfirmationPath, account.Token, client.ValidateConfirmation{Token: confirmation}, http.StatusOK)
+	if err != nil {
+		return fmt.Errorf("unable to validate confirmation token: %s", err.Error())
+	}
+
+	// billing is left to the subscribe stage, chaos would subscribe the account a second time
+	confirm := client.AccountConfirmation{
+		SGToken:     account.SGToken,
+		PackageUUID: t.PackageUUID,
+	}
+	err = postChaos(accountConfirmationPath, account.Token, confirm, http.StatusOK)
+	if err != nil {
+		return fmt.Errorf("unable to confirm account: %s", err.Error())
+	}
+
+	user, adaptorErr := ApidAdaptor.GetUser(account.UserID)
+	if adaptorErr != nil {
+		return fmt.Errorf("unable to read back user: %s", adaptorErr.Error())
+	}
+	if !user.Active {
+		return fmt.Errorf("user is not active after confirming")
+	}
+	return nil
+}
+
+// postChaos posts the json body to chaos as the user, expecting the status
+func postChaos(path string, token string, body interface{}, status int) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", Conf.Chaos+path, bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", `application/json`)
+	req.Header.Set("Authorization", "token "+token)
+
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != status {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("got status %d from %s, want %d: %s", resp.StatusCode, path, status, string(b))
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+
+	"github.com/yvasiyarov/php_session_decoder/php_serialize"
+)
+
+// the cookies the marketing site sets and chaos passes on as SignupBI and SendGridPartner
+const (
+	BICookie      = "sendgrid_bi"
+	PartnerCookie = "sendgrid_partner"
+)
+
+// the ways a cookie can be broken, each one is meant to fail a different check in chaos's AddUserBI and AddUserPartner,
+// apart from a bi cookie missing its key, which chaos accepts
+const (
+	// MalformedBase64 is not base64 at all, both cookies fail to decode
+	MalformedBase64 = "base64"
+	// MalformedPayload is base64 of something that is not json or a php array,
+	// the bi cookie fails to unmarshal and the partner cookie fails to unserialize
+	MalformedPayload = "payload"
+	// MalformedMissingKey leaves out mcd or partner_credential. The partner array fails the partner_credential
+	// lookup, the bi json still unmarshals and chaos stores the bi without a marketing channel detail
+	MalformedMissingKey = "missing_key"
+	// MalformedWrongType gives mc or partner a number instead of a string,
+	// the bi cookie fails to unmarshal into mc and the partner cookie fails the conversion of partner
+	MalformedWrongType = "wrong_type"
+)
+
+var malformedKinds = []string{MalformedBase64, MalformedPayload, MalformedMissingKey, MalformedWrongType}
+
+// the answers the marketing site tracks
+var (
+	defaultMarketingChannels = []string{
+		"Paid Search",
+		"Organic Search",
+		"Referral",
+		"Direct",
+		"Social",
+		"Email",
+	}
+	defaultMarketingChannelDetails = []string{
+		"google",
+		"bing",
+		"twitter",
+		"newsletter",
+		"docs",
+	}
+)
+
+// CookieOptions attaches attribution cookies to the signup of every account of a template
+type CookieOptions struct {
+	BI      *BICookieOptions      `json:"bi"`
+	Partner *PartnerCookieOptions `json:"partner"`
+}
+
+// BICookieOptions makes the tracking cookie, base64 json with the marketing channel (mc) and its detail (mcd)
+type BICookieOptions struct {
+	Channels []string `json:"channels"`
+	Details  []string `json:"details"`
+	// Malformed breaks the cookie in one of the malformedKinds ways
+	Malformed string `json:"malformed"`
+}
+
+// PartnerCookieOptions makes the partner cookie, a base64 php array of the partner label and the partner credential
+type PartnerCookieOptions struct {
+	// Partners are labels of partners apid knows about
+	Partners []string `json:"partners"`
+	// Credentials are picked from for partner_credential, a random number is used when empty
+	Credentials []string `json:"credentials"`
+	Malformed   string   `json:"malformed"`
+}
+
+func (o *CookieOptions) validate() error {
+	if o.BI != nil {
+		err := validateMalformed(o.BI.Malformed)
+		if err != nil {
+			return fmt.Errorf("bi cookie: %s", err.Error())
+		}
+	}
+	if o.Partner != nil {
+		err := validateMalformed(o.Partner.Malformed)
+		if err != nil {
+			return fmt.Errorf("partner cookie: %s", err.Error())
+		}
+		if len(o.Partner.Partners) == 0 && o.Partner.Malformed == "" {
+			return fmt.Errorf("partner cookie needs partners")
+		}
+	}
+	return nil
+}
+
+func validateMalformed(kind string) error {
+	if kind == "" {
+		return nil
+	}
+	for _, k := range malformedKinds {
+		if kind == k {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown malformed kind %q, known kinds are %s", kind, strings.Join(malformedKinds, ", "))
+}
+
+// signupCookies makes the cookies for one signup
+func signupCookies(options *CookieOptions, r *rand.Rand) ([]*http.Cookie, error) {
+	cookies := []*http.Cookie{}
+	if options == nil {
+		return cookies, nil
+	}
+
+	if options.BI != nil {
+		value, err := biCookie(options.BI, r)
+		if err != nil {
+			return nil, err
+		}
+		cookies = append(cookies, &http.Cookie{Name: BICookie, Value: value})
+	}
+
+	if options.Partner != nil {
+		value, err := partnerCookie(options.Partner, r)
+		if err != nil {
+			return nil, err
+		}
+		cookies = append(cookies, &http.Cookie{Name: PartnerCookie, Value: value})
+	}
+
+	return cookies, nil
+}
+
+func biCookie(options *BICookieOptions, r *rand.Rand) (string, error) {
+	bi := map[string]interface{}{
+		"mc":  pick(r, orDefault(options.Channels, defaultMarketingChannels)),
+		"mcd": pick(r, orDefault(options.Details, defaultMarketingChannelDetails)),
+	}
+
+	switch options.Malformed {
+	case MalformedBase64:
+		return malformedBase64(r), nil
+	case MalformedPayload:
+		return base64.StdEncoding.EncodeToString([]byte(`{"mc":`)), nil
+	case MalformedMissingKey:
+		delete(bi, "mcd")
+	case MalformedWrongType:
+		bi["mc"] = r.Intn(100)
+	}
+
+	data, err := json.Marshal(bi)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+func partnerCookie(options *PartnerCookieOptions, r *rand.Rand) (string, error) {
+	partner := php_serialize.PhpArray{
+		"partner":            pick(r, orDefault(options.Partners, []string{"user_generator"})),
+		"partner_credential": r.Intn(1000000),
+	}
+	if len(options.Credentials) > 0 {
+		partner["partner_credential"] = pick(r, options.Credentials)
+	}
+
+	switch options.Malformed {
+	case MalformedBase64:
+		return malformedBase64(r), nil
+	case MalformedPayload:
+		return base64.StdEncoding.EncodeToString([]byte(`a:2:{s:7:"partner";`)), nil
+	case MalformedMissingKey:
+		delete(partner, "partner_credential")
+	case MalformedWrongType:
+		partner["partner"] = r.Intn(100)
+	}
+
+	data, err := php_serialize.Serialize(partner)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString([]byte(data)), nil
+}
+
+// malformedBase64 has characters base64 never uses, still allowed in a cookie
+func malformedBase64(r *rand.Rand) string {
+	return fmt.Sprintf("!not*base64~%d", r.Intn(1000))
+}
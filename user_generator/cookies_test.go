@@ -0,0 +1,31 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"math/rand"
+	"testing"
+)
+
+func TestBICookieMissingKey(t *testing.T) {
+	value, err := biCookie(&BICookieOptions{Malformed: MalformedMissingKey}, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatalf("unable to make the cookie: %s", err.Error())
+	}
+
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		t.Fatalf("cookie should be base64, got %s", value)
+	}
+	var bi map[string]interface{}
+	err = json.Unmarshal(data, &bi)
+	if err != nil {
+		t.Fatalf("cookie should be json, got %s", string(data))
+	}
+	if _, ok := bi["mcd"]; ok {
+		t.Errorf("cookie should leave out mcd, got %s", string(data))
+	}
+	if _, ok := bi["mc"].(string); !ok {
+		t.Errorf("cookie should keep mc, got %s", string(data))
+	}
+}
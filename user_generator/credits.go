@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	apidadaptor "github.com/sendgrid/chaos/adaptor/apid"
+)
+
+// creditLimitRow is a user's credit limit as apid returns it
+type creditLimitRow struct {
+	Credits int    `json:"credits"`
+	Period  string `json:"period"`
+}
+
+// applyCreditLimit sets the template's credit limit, or the starting credits of a lite package
+// when the template has none, and checks it was stored
+func applyCreditLimit(t AccountTemplate, userID int) error {
+	limit, err := creditLimitFor(t, userID)
+	if err != nil || limit == nil {
+		return err
+	}
+
+	_, adaptorErr := ApidAdaptor.SetCreditLimits(userID, limit.Credits, limit.Period)
+	if adaptorErr != nil {
+		return fmt.Errorf("unable to set %s credit limit of %d: %s", limit.Period, limit.Credits, adaptorErr.Error())
+	}
+
+	return verifyCreditLimit(userID, limit)
+}
+
+// creditLimitFor returns the limit the user should have, nil when it should not have one
+func creditLimitFor(t AccountTemplate, userID int) (*CreditLimit, error) {
+	if t.CreditLimit != nil {
+		return t.CreditLimit, nil
+	}
+
+	userPackage, adaptorErr := ApidAdaptor.GetUserPackage(userID)
+	if adaptorErr != nil {
+		return nil, fmt.Errorf("unable to get package: %s", adaptorErr.Error())
+	}
+	if !userPackage.IsLite {
+		return nil, nil
+	}
+
+	credits, adaptorErr := ApidAdaptor.GetLitePlanStartingCredits(userPackage.ID)
+	if adaptorErr != nil {
+		return nil, fmt.Errorf("unable to get starting credits for lite package %d: %s", userPackage.ID, adaptorErr.Error())
+	}
+
+	return &CreditLimit{Credits: credits, Period: apidadaptor.LitePlanCreditPeriod}, nil
+}
+
+// verifyCreditLimit reads the limit back so a silently dropped write is caught
+func verifyCreditLimit(userID int, want *CreditLimit) error {
+	var raw json.RawMessage
+	err := ApidClient.DoFunction("getUserCreditLimit", url.Values{
+		"userid": []string{strconv.Itoa(userID)},
+	}, &raw)
+	if err != nil {
+		return fmt.Errorf("unable to read back credit limit: %s", err.Error())
+	}
+
+	// apid hands back the row on its own or in a list
+	var rows []creditLimitRow
+	if json.Unmarshal(raw, &rows) != nil {
+		var row creditLimitRow
+		err = json.Unmarshal(raw, &row)
+		if err != nil {
+			return fmt.Errorf("unable to read back credit limit: %s", err.Error())
+		}
+		rows = []creditLimitRow{row}
+	}
+
+	if len(rows) != 1 || rows[0].Credits != want.Credits || rows[0].Period != want.Period {
+		return fmt.Errorf("credit limit should be %d %s, got %+v", want.Credits, want.Period, rows)
+	}
+
+	return nil
+}
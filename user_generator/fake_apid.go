@@ -0,0 +1,816 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	apidadaptor "github.com/sendgrid/chaos/adaptor/apid"
+	"github.com/sendgrid/go-apid"
+	"github.com/sendgrid/go-apid/clientfakes"
+)
+
+// FakeApidIPs is how many free external ips the fake apid starts with, they come from DefaultSeedNetwork
+const FakeApidIPs = 4096
+
+// fakeApidPath is where the fake apid serves each function, the function list points there
+const fakeApidPath = "/api/fn/"
+
+// fakeIntColumns are stored as numbers whatever the caller sent, the way mysql hands them back
+var fakeIntColumns = map[string]bool{
+	"active":               true,
+	"credits":              true,
+	"filter_id":            true,
+	"group_id":             true,
+	"id":                   true,
+	"in_sender_score":      true,
+	"initial_package":      true,
+	"is_deleted":           true,
+	"is_reseller_disabled": true,
+	"moving":               true,
+	"new_provider":         true,
+	"outbound_cluster_id":  true,
+	"package_group_id":     true,
+	"package_id":           true,
+	"package_status":       true,
+	"partner_id":           true,
+	"reason":               true,
+	"reseller_id":          true,
+	"server_location_id":   true,
+	"server_name_id":       true,
+	"status":               true,
+	"subusers_limit":       true,
+	"upgrade_package_id":   true,
+	"user_id":              true,
+}
+
+// fakePrefixQuery is the query of prefixQuery, the like pattern is the match
+var fakePrefixQuery = regexp.MustCompile(`^select id, username from user where username like "((?:[^"\\]|\\.)*)%" escape '\\\\' and reseller_id = 0$`)
+
+// fakeLikeUnescaper undoes likeEscaper
+var fakeLikeUnescaper = strings.NewReplacer(`\_`, `_`, `\%`, `%`, `\\`, `\`)
+
+// fakeRow is a row in one of the fake apid's tables
+type fakeRow map[string]interface{}
+
+func (r fakeRow) copy() fakeRow {
+	c := make(fakeRow, len(r))
+	for k, v := range r {
+		c[k] = v
+	}
+	return c
+}
+
+// FakeApid is an in-process apid with users, ips and packages kept in memory.
+// Its functions are registered on a clientfakes.FakeClient and served with the function list go-apid looks them up in.
+// The fake client runs one function at a time, so the tables need no lock of their own
+type FakeApid struct {
+	URL string
+
+	server    *httptest.Server
+	client    *clientfakes.FakeClient
+	functions []string
+	tables    map[string][]fakeRow
+	lastID    map[string]int
+}
+
+// startFakeApid starts a fake apid seeded with the packages, coupons and ips the example scenarios use
+func startFakeApid() *FakeApid {
+	f := &FakeApid{
+		client: clientfakes.NewFakeClient(),
+		tables: make(map[string][]fakeRow),
+		lastID: make(map[string]int),
+	}
+	f.seed()
+	f.register(map[string]func(url.Values) (interface{}, error){
+		"add":                               f.add,
+		"addBounceManagementSettings":       f.addBounceManagementSettings,
+		"addExternalIp":                     f.addExternalIP,
+		"addUser":                           f.addUser,
+		"addUserFilters":                    f.addUserFilters,
+		"addUserIpGroup":                    f.addUserIPGroup,
+		"addUserProfile":                    f.addUserProfile,
+		"addUserSendIp":                     f.addUserSendIP,
+		"assignBestAvailableOp":             f.assignBestAvailableOp,
+		"countSubusers":                     f.countSubusers,
+		"deleteAllUserIpGroups":             f.deleteForUsers("user_ip_group"),
+		"deleteAllUserIps":                  f.deleteForUsers("user_send_ip"),
+		"deleteAllUserNotificationSettings": f.deleteForUsers("user_notification_settings"),
+		"deleteUserAlerts":                  f.deleteForUsers("user_alert"),
+		"delete":                            f.remove,
+		"editExternalIp":                    f.editExternalIP,
+		"editUser":                          f.editUser,
+		"editUserProfile":                   f.editUserProfile,
+		"enableUserFilter":                  f.enableUserFilter,
+		"executeSql":                        f.executeSQL,
+		"get":                               f.get,
+		"getAssignmentPolicy":               f.getAssignmentPolicy,
+		"getBestAvailableIp":                f.getBestAvailableIP,
+		"getExternalIp":                     f.getExternalIP,
+		"getHealthcheck":                    f.getHealthcheck,
+		"getPackage":                        f.getPackage,
+		"getUserCreditLimit":                f.getUserCreditLimit,
+		"getUserHolds":                      f.getUserHolds,
+		"getUserInfo":                       f.getUserInfo,
+		"getUserInfoMaster":                 f.getUserInfo,
+		"getUseridsByReseller":              f.getUserIDsByReseller,
+		"getUserPackageType":                f.getUserPackageType,
+		"getUserProfile":                    f.getUserProfile,
+		"getUserSendIp":                     f.getUserSendIP,
+		"removeUserCreditLimit":             f.removeUserCreditLimit,
+		"removeUserIpGroup":                 f.removeUserIPGroup,
+		"setUserActive":                     f.setUserActive,
+		"setUserCreditLimit":                f.setUserCreditLimit,
+		"setUserIpGroup":                    f.setUserIPGroup,
+		"softDeleteSubusers":                f.softDeleteSubusers,
+		"softDeleteUser":                    f.softDeleteUser,
+		"unassignExternalIps":               f.unassignExternalIPs,
+		"update":                            f.update,
+		"validateExternalIps":               f.validateExternalIPs,
+	})
+
+	f.server = httptest.NewServer(f)
+	f.URL = f.server.URL
+	return f
+}
+
+// register adds the functions to the fake client, which can not list them, so their names are kept for functions.json
+func (f *FakeApid) register(functions map[string]func(url.Values) (interface{}, error)) {
+	for name, function := range functions {
+		f.client.RegisterFunction(apid.APIdFunction(name), function)
+		f.functions = append(f.functions, name)
+	}
+}
+
+func (f *FakeApid) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch {
+	case r.URL.Path == "/api/functions.json":
+		functions := make(map[string]apid.FunctionInfo, len(f.functions))
+		for _, name := range f.functions {
+			functions[name] = apid.FunctionInfo{Return: "result", Path: fakeApidPath + name}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"functions": functions})
+	case strings.HasPrefix(r.URL.Path, fakeApidPath):
+		name := strings.TrimPrefix(r.URL.Path, fakeApidPath)
+		var result interface{}
+		err := f.client.DoFunction(apid.APIdFunction(name), r.URL.Query(), &result)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"result": result})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// seed adds the packages and coupons of the example scenarios, the churn reasons and competitors apid ships with,
+// and a first_ip location full of free ips
+func (f *FakeApid) seed() {
+	packages := []struct {
+		id, group int
+		name      string
+		credits   float64
+		price     string
+		hasIP     int
+		isFree    int
+	}{
+		{DefaultPackageID, 1, "Legacy Free", 12000, "0.00", 0, 1},
+		{apidadaptor.FreePackageID, apidadaptor.FreePackageGroupID, "Free 12K", apidadaptor.FreeAccountCreditsLimits, "0.00", 0, 1},
+		{109, 2, "Essentials 40K", 40000, "9.95", 0, 0},
+		{110, 3, "Pro 100K", 100000, "79.95", 1, 0},
+	}
+	groups := map[int]string{1: "legacy", apidadaptor.FreePackageGroupID: "free", 2: "essentials", 3: "pro"}
+	for id, name := range groups {
+		f.insert("package_group", fakeRow{"id": id, "name": name})
+	}
+	for _, p := range packages {
+		f.insert("package", fakeRow{
+			"id":               p.id,
+			"package_group_id": p.group,
+			"name":             p.name,
+			"uuid":             fmt.Sprintf("00000000-0000-0000-0000-%012d", p.id),
+			"credits":          p.credits,
+			"price":            p.price,
+			"price_per_email":  "0.00",
+			"has_ip":           p.hasIP,
+			"is_free":          p.isFree,
+			"is_lite":          0,
+		})
+	}
+
+	f.insert("coupon", fakeRow{"code": "QA10OFF", "type": "percent", "value": 10, "periods": 1, "valid": true})
+	f.insert("partner", fakeRow{"label": "user_generator"})
+	for _, reason := range []string{"Too expensive", "Missing features", "Deliverability", "No longer needed"} {
+		f.insert("user_churn_reason", fakeRow{"reason": reason})
+	}
+	for _, competitor := range []string{"Mailgun", "Mandrill", "Amazon SES", "Mailchimp", "SparkPost", InHouseCompetitor} {
+		f.insert("competitors", fakeRow{"competitor": competitor, "user_id": 0})
+	}
+	// a name a user typed in that BI has not vetted yet
+	f.insert("competitors", fakeRow{"competitor": "Sendinblue", "user_id": 1})
+
+	// like seed-ips, the first host is the server the external ips live on
+	_, network, _ := net.ParseCIDR(DefaultSeedNetwork)
+	ip := nextIP(network.IP)
+	server := f.insert("server_name", fakeRow{"name": ip.String(), "server_location_id": SteadfastLocationId})
+	f.insert("ip_assignment_policy", fakeRow{"policy": apidadaptor.FirstIPPolicy, "server_location_id": SteadfastLocationId})
+	for i := 0; i < FakeApidIPs; i++ {
+		ip = nextIP(ip)
+		f.insert("external_ip", fakeRow{"ip": ip.String(), "server_name_id": server["id"], "reseller_id": 0, "in_sender_score": 0})
+	}
+}
+
+// insert adds the row to the table, giving it the next id unless it has one
+func (f *FakeApid) insert(table string, row fakeRow) fakeRow {
+	id, ok := row["id"].(int)
+	if !ok {
+		id = f.lastID[table] + 1
+		row["id"] = id
+	}
+	if id > f.lastID[table] {
+		f.lastID[table] = id
+	}
+	f.tables[table] = append(f.tables[table], row)
+	return row
+}
+
+// find returns the rows matching every column of where, they are the rows in the table so they can be updated
+func (f *FakeApid) find(table string, where fakeRow) []fakeRow {
+	rows := make([]fakeRow, 0)
+	for _, row := range f.tables[table] {
+		if matches(row, where) {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// first returns a copy of the first row matching where, nil when there is none
+func (f *FakeApid) first(table string, where fakeRow) fakeRow {
+	rows := f.find(table, where)
+	if len(rows) == 0 {
+		return nil
+	}
+	return rows[0].copy()
+}
+
+// copyRows copies rows that are handed back, they are encoded after the next function may have changed them
+func copyRows(rows []fakeRow) []fakeRow {
+	copies := make([]fakeRow, len(rows))
+	for i, row := range rows {
+		copies[i] = row.copy()
+	}
+	return copies
+}
+
+func (f *FakeApid) updateRows(table string, where fakeRow, values fakeRow) int {
+	rows := f.find(table, where)
+	for _, row := range rows {
+		for k, v := range values {
+			row[k] = v
+		}
+	}
+	return len(rows)
+}
+
+func (f *FakeApid) deleteRows(table string, where fakeRow) int {
+	kept := make([]fakeRow, 0, len(f.tables[table]))
+	for _, row := range f.tables[table] {
+		if !matches(row, where) {
+			kept = append(kept, row)
+		}
+	}
+	deleted := len(f.tables[table]) - len(kept)
+	f.tables[table] = kept
+	return deleted
+}
+
+// matches compares the way apid's where clauses do, where 5 and "5" are the same
+func matches(row fakeRow, where fakeRow) bool {
+	for k, v := range where {
+		if fmt.Sprint(row[k]) != fmt.Sprint(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// fakeValue stores numbers as numbers, and strings as numbers in the columns that hold them
+func fakeValue(column string, v interface{}) interface{} {
+	switch value := v.(type) {
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			return int(i)
+		}
+		n, _ := value.Float64()
+		return n
+	case string:
+		if i, err := strconv.Atoi(value); err == nil && fakeIntColumns[column] {
+			return i
+		}
+	}
+	return v
+}
+
+// paramValues are the params as columns, leaving out the ones that pick the row
+func paramValues(params url.Values, skip ...string) fakeRow {
+	values := make(fakeRow)
+	for k := range params {
+		values[k] = fakeValue(k, params.Get(k))
+	}
+	for _, k := range skip {
+		delete(values, k)
+	}
+	return values
+}
+
+func crudWhere(params url.Values) (fakeRow, error) {
+	where := make(fakeRow)
+	if params.Get("where") == "" {
+		return where, nil
+	}
+
+	var raw map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(params.Get("where")))
+	decoder.UseNumber()
+	err := decoder.Decode(&raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid where: %s", err.Error())
+	}
+	for k, v := range raw {
+		where[k] = fakeValue(k, v)
+	}
+	return where, nil
+}
+
+// crudValues merges the list of column objects crud functions take into one row
+func crudValues(params url.Values) (fakeRow, error) {
+	var raw []map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(params.Get("values")))
+	decoder.UseNumber()
+	err := decoder.Decode(&raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid values: %s", err.Error())
+	}
+
+	values := make(fakeRow)
+	for _, columns := range raw {
+		for k, v := range columns {
+			values[k] = fakeValue(k, v)
+		}
+	}
+	return values, nil
+}
+
+func tableName(params url.Values) (string, error) {
+	table := params.Get("tableName")
+	if table == "" {
+		return "", errors.New("tableName is required")
+	}
+	return table, nil
+}
+
+func (f *FakeApid) add(params url.Values) (interface{}, error) {
+	table, err := tableName(params)
+	if err != nil {
+		return nil, err
+	}
+	values, err := crudValues(params)
+	if err != nil {
+		return nil, err
+	}
+
+	f.insert(table, values)
+	return apidadaptor.ApidSuccess, nil
+}
+
+func (f *FakeApid) get(params url.Values) (interface{}, error) {
+	table, err := tableName(params)
+	if err != nil {
+		return nil, err
+	}
+	where, err := crudWhere(params)
+	if err != nil {
+		return nil, err
+	}
+
+	return copyRows(f.find(table, where)), nil
+}
+
+func (f *FakeApid) update(params url.Values) (interface{}, error) {
+	table, err := tableName(params)
+	if err != nil {
+		return nil, err
+	}
+	where, err := crudWhere(params)
+	if err != nil {
+		return nil, err
+	}
+	values, err := crudValues(params)
+	if err != nil {
+		return nil, err
+	}
+
+	f.updateRows(table, where, values)
+	return apidadaptor.ApidSuccess, nil
+}
+
+func (f *FakeApid) remove(params url.Values) (interface{}, error) {
+	table, err := tableName(params)
+	if err != nil {
+		return nil, err
+	}
+	where, err := crudWhere(params)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.deleteRows(table, where), nil
+}
+
+// executeSQL only knows the select cleanup finds users with, the like is case insensitive like mysql's
+func (f *FakeApid) executeSQL(params url.Values) (interface{}, error) {
+	match := fakePrefixQuery.FindStringSubmatch(params.Get("query"))
+	if match == nil {
+		return nil, errors.New("executeSql is only simulated for cleanup -prefix, seed-ips needs a real apid")
+	}
+	prefix := strings.ToLower(fakeLikeUnescaper.Replace(match[1]))
+
+	users := make([]fakeRow, 0)
+	for _, user := range f.find("user", fakeRow{"reseller_id": 0}) {
+		username := user["username"].(string)
+		if strings.HasPrefix(strings.ToLower(username), prefix) {
+			users = append(users, fakeRow{"id": user["id"], "username": username})
+		}
+	}
+	return users, nil
+}
+
+func (f *FakeApid) getHealthcheck(params url.Values) (interface{}, error) {
+	return "ok", nil
+}
+
+func (f *FakeApid) addUser(params url.Values) (interface{}, error) {
+	username := params.Get("username")
+	if username == "" || params.Get("email") == "" || params.Get("password") == "" {
+		return nil, errors.New("username, email and password are required")
+	}
+	if f.first("user", fakeRow{"username": username}) != nil {
+		return nil, fmt.Errorf("key exists for username %s", username)
+	}
+
+	user := fakeRow{
+		"username":             username,
+		"email":                params.Get("email"),
+		"active":               0,
+		"reseller_id":          0,
+		"is_reseller_disabled": 0,
+		"is_deleted":           0,
+	}
+	for _, column := range []string{"active", "reseller_id", "outbound_cluster_id"} {
+		if params.Get(column) != "" {
+			user[column] = fakeValue(column, params.Get(column))
+		}
+	}
+	return f.insert("user", user)["id"], nil
+}
+
+// getUserInfo finds the user by id or username, apid answers an unknown username with an empty user
+func (f *FakeApid) getUserInfo(params url.Values) (interface{}, error) {
+	if username := params.Get("username"); username != "" {
+		user := f.first("user", fakeRow{"username": username})
+		if user == nil {
+			return fakeRow{}, nil
+		}
+		return user, nil
+	}
+
+	user := f.first("user", fakeRow{"id": params.Get("userid")})
+	if user == nil {
+		return nil, fmt.Errorf("Invalid user id: %s", params.Get("userid"))
+	}
+	return user, nil
+}
+
+func (f *FakeApid) editUser(params url.Values) (interface{}, error) {
+	return f.updateRows("user", fakeRow{"id": params.Get("userid")}, paramValues(params, "userid")), nil
+}
+
+func (f *FakeApid) setUserActive(params url.Values) (interface{}, error) {
+	return f.updateRows("user", fakeRow{"id": params.Get("userid")}, paramValues(params, "userid")), nil
+}
+
+func (f *FakeApid) softDeleteUser(params url.Values) (interface{}, error) {
+	return f.updateRows("user", fakeRow{"id": params.Get("userid")}, fakeRow{"is_deleted": 1, "active": 0}), nil
+}
+
+func (f *FakeApid) softDeleteSubusers(params url.Values) (interface{}, error) {
+	return f.updateRows("user", fakeRow{"reseller_id": params.Get("userid")}, fakeRow{"is_deleted": 1, "active": 0}), nil
+}
+
+// userIDs are the ids of the bulk functions, the adaptor sends an empty one for every id it sends
+func userIDs(params url.Values) []string {
+	ids := make([]string, 0, len(params["userids"]))
+	for _, id := range params["userids"] {
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// deleteForUsers makes a bulk function that deletes the users' rows from the table
+func (f *FakeApid) deleteForUsers(table string) func(url.Values) (interface{}, error) {
+	return func(params url.Values) (interface{}, error) {
+		deleted := 0
+		for _, id := range userIDs(params) {
+			deleted += f.deleteRows(table, fakeRow{"user_id": id})
+		}
+		return deleted, nil
+	}
+}
+
+func (f *FakeApid) unassignExternalIPs(params url.Values) (interface{}, error) {
+	unassigned := 0
+	for _, id := range userIDs(params) {
+		unassigned += f.updateRows("external_ip", fakeRow{"reseller_id": id}, fakeRow{"reseller_id": 0})
+	}
+	return unassigned, nil
+}
+
+func (f *FakeApid) getUserIDsByReseller(params url.Values) (interface{}, error) {
+	ids := make([]int, 0)
+	for _, user := range f.find("user", fakeRow{"reseller_id": params.Get("reseller_id")}) {
+		ids = append(ids, user["id"].(int))
+	}
+	return ids, nil
+}
+
+func (f *FakeApid) countSubusers(params url.Values) (interface{}, error) {
+	where := fakeRow{"reseller_id": params.Get("userid")}
+	if params.Get("username") != "" {
+		where["username"] = params.Get("username")
+	}
+	return len(f.find("user", where)), nil
+}
+
+func (f *FakeApid) addUserProfile(params url.Values) (interface{}, error) {
+	profile := paramValues(params, "userid")
+	profile["user_id"] = fakeValue("user_id", params.Get("userid"))
+	f.insert("user_profile", profile)
+	return 1, nil
+}
+
+func (f *FakeApid) editUserProfile(params url.Values) (interface{}, error) {
+	return f.updateRows("user_profile", fakeRow{"user_id": params.Get("userid")}, paramValues(params, "userid")), nil
+}
+
+func (f *FakeApid) getUserProfile(params url.Values) (interface{}, error) {
+	profile := f.first("user_profile", fakeRow{"user_id": params.Get("userid")})
+	if profile == nil {
+		return nil, fmt.Errorf("no profile for user %s", params.Get("userid"))
+	}
+	return profile, nil
+}
+
+func (f *FakeApid) getPackage(params url.Values) (interface{}, error) {
+	pkg := f.first("package", fakeRow{"id": params.Get("id")})
+	if pkg == nil {
+		return nil, fmt.Errorf("Invalid package id: %s", params.Get("id"))
+	}
+	return pkg, nil
+}
+
+// getUserPackageType joins the user's package with the package, wrapped the way apid wraps it
+func (f *FakeApid) getUserPackageType(params url.Values) (interface{}, error) {
+	userPackage := f.first("user_package", fakeRow{"user_id": params.Get("userid")})
+	if userPackage == nil {
+		return nil, fmt.Errorf("no package for user %s", params.Get("userid"))
+	}
+
+	result := fakeRow{
+		"userid":         userPackage["user_id"],
+		"status":         userPackage["package_status"],
+		"package_status": fmt.Sprint(userPackage["package_status"]),
+		"subusers_limit": userPackage["subusers_limit"],
+	}
+	if userPackage["package_status"] == nil {
+		// subusers share their reseller's package
+		delete(result, "status")
+		delete(result, "package_status")
+	}
+
+	pkg := f.first("package", fakeRow{"id": userPackage["package_id"]})
+	if pkg != nil {
+		result["id"] = pkg["id"]
+		result["name"] = pkg["name"]
+		result["uuid"] = pkg["uuid"]
+		result["price"] = pkg["price"]
+		result["price_per_email"] = pkg["price_per_email"]
+		result["has_ip"] = pkg["has_ip"] == 1
+		result["is_lite"] = pkg["is_lite"] == 1
+	}
+	return fakeRow{"package": result}, nil
+}
+
+func (f *FakeApid) setUserCreditLimit(params url.Values) (interface{}, error) {
+	f.deleteRows("user_credit_limit", fakeRow{"user_id": params.Get("userid")})
+	f.insert("user_credit_limit", fakeRow{
+		"user_id": fakeValue("user_id", params.Get("userid")),
+		"credits": fakeValue("credits", params.Get("credits")),
+		"period":  params.Get("period"),
+	})
+	return 1, nil
+}
+
+func (f *FakeApid) removeUserCreditLimit(params url.Values) (interface{}, error) {
+	return f.deleteRows("user_credit_limit", fakeRow{"user_id": params.Get("userid")}), nil
+}
+
+func (f *FakeApid) getUserCreditLimit(params url.Values) (interface{}, error) {
+	return copyRows(f.find("user_credit_limit", fakeRow{"user_id": params.Get("userid")})), nil
+}
+
+func (f *FakeApid) enableUserFilter(params url.Values) (interface{}, error) {
+	if params.Get("type") == "" {
+		return nil, errors.New("type is required")
+	}
+	return 1, nil
+}
+
+func (f *FakeApid) addUserFilters(params url.Values) (interface{}, error) {
+	where := fakeRow{"user_id": params.Get("userid"), "filter_id": params.Get("filterid")}
+	f.deleteRows("user_filters", where)
+	f.insert("user_filters", fakeRow{
+		"user_id":   fakeValue("user_id", params.Get("userid")),
+		"filter_id": fakeValue("filter_id", params.Get("filterid")),
+		"settings":  params.Get("settings"),
+	})
+	return 1, nil
+}
+
+func (f *FakeApid) addBounceManagementSettings(params url.Values) (interface{}, error) {
+	f.deleteRows("bounce_management", fakeRow{"user_id": params.Get("userid")})
+	f.insert("bounce_management", fakeRow{
+		"user_id":       fakeValue("user_id", params.Get("userid")),
+		"block_expired": params.Get("block_expired"),
+	})
+	return 1, nil
+}
+
+// getUserHolds maps each of the user's holds by its reason
+func (f *FakeApid) getUserHolds(params url.Values) (interface{}, error) {
+	holds := make(fakeRow)
+	for _, hold := range f.find(UserHoldTable, fakeRow{"user_id": params.Get("userid")}) {
+		holds[fmt.Sprint(hold["reason"])] = hold.copy()
+	}
+	return holds, nil
+}
+
+func (f *FakeApid) addUserIPGroup(params url.Values) (interface{}, error) {
+	f.insert("user_ip_group", fakeRow{
+		"user_id":  fakeValue("user_id", params.Get("userid")),
+		"group_id": fakeValue("group_id", params.Get("groupid")),
+	})
+	return 1, nil
+}
+
+func (f *FakeApid) setUserIPGroup(params url.Values) (interface{}, error) {
+	where := fakeRow{"user_id": params.Get("userid"), "group_id": params.Get("oldgroupid")}
+	if f.updateRows("user_ip_group", where, fakeRow{"group_id": fakeValue("group_id", params.Get("groupid"))}) == 0 {
+		return f.addUserIPGroup(params)
+	}
+	return 1, nil
+}
+
+func (f *FakeApid) removeUserIPGroup(params url.Values) (interface{}, error) {
+	return f.deleteRows("user_ip_group", fakeRow{"user_id": params.Get("userid"), "group_id": params.Get("groupid")}), nil
+}
+
+func (f *FakeApid) getAssignmentPolicy(params url.Values) (interface{}, error) {
+	locations := make([]int, 0)
+	for _, policy := range f.find("ip_assignment_policy", fakeRow{"policy": params.Get("policy")}) {
+		locations = append(locations, policy["server_location_id"].(int))
+	}
+	return locations, nil
+}
+
+// availableIPs are unassigned external ips on the servers in the location
+func (f *FakeApid) availableIPs(params url.Values) ([]fakeRow, error) {
+	limit, err := strconv.Atoi(params.Get("limit"))
+	if err != nil {
+		return nil, errors.New("limit must be a number")
+	}
+
+	servers := make(map[string]bool)
+	for _, server := range f.find("server_name", fakeRow{"server_location_id": params.Get("server_location")}) {
+		servers[fmt.Sprint(server["id"])] = true
+	}
+
+	available := make([]fakeRow, 0, limit)
+	for _, ip := range f.find("external_ip", fakeRow{"reseller_id": 0}) {
+		if len(available) == limit {
+			break
+		}
+		if servers[fmt.Sprint(ip["server_name_id"])] {
+			available = append(available, ip)
+		}
+	}
+	return available, nil
+}
+
+func (f *FakeApid) getBestAvailableIP(params url.Values) (interface{}, error) {
+	available, err := f.availableIPs(params)
+	if err != nil {
+		return nil, err
+	}
+
+	ips := make([]string, 0, len(available))
+	for _, ip := range available {
+		ips = append(ips, ip["ip"].(string))
+	}
+	return ips, nil
+}
+
+func (f *FakeApid) assignBestAvailableOp(params url.Values) (interface{}, error) {
+	available, err := f.availableIPs(params)
+	if err != nil {
+		return nil, err
+	}
+
+	ips := make([]string, 0, len(available))
+	for _, ip := range available {
+		ip["reseller_id"] = fakeValue("reseller_id", params.Get("userid"))
+		ips = append(ips, ip["ip"].(string))
+	}
+	return ips, nil
+}
+
+// getExternalIP answers an unknown ip with no rows, like apid
+func (f *FakeApid) getExternalIP(params url.Values) (interface{}, error) {
+	return copyRows(f.find("external_ip", fakeRow{"ip": params.Get("ip")})), nil
+}
+
+func (f *FakeApid) addExternalIP(params url.Values) (interface{}, error) {
+	if f.first("external_ip", fakeRow{"ip": params.Get("ip")}) != nil {
+		return nil, fmt.Errorf("key exists for ip %s", params.Get("ip"))
+	}
+	f.insert("external_ip", fakeRow{
+		"ip":              params.Get("ip"),
+		"server_name_id":  fakeValue("server_name_id", params.Get("server_name_id")),
+		"reseller_id":     0,
+		"in_sender_score": 0,
+	})
+	return 1, nil
+}
+
+func (f *FakeApid) editExternalIP(params url.Values) (interface{}, error) {
+	return f.updateRows("external_ip", fakeRow{"ip": params.Get("ip")}, paramValues(params, "ip")), nil
+}
+
+func (f *FakeApid) addUserSendIP(params url.Values) (interface{}, error) {
+	where := fakeRow{"user_id": params.Get("userid"), "ip": params.Get("ip")}
+	if len(f.find("user_send_ip", where)) > 0 {
+		return 0, nil
+	}
+	f.insert("user_send_ip", fakeRow{"user_id": fakeValue("user_id", params.Get("userid")), "ip": params.Get("ip")})
+	return 1, nil
+}
+
+func (f *FakeApid) getUserSendIP(params url.Values) (interface{}, error) {
+	ips := make([]string, 0)
+	for _, ip := range f.find("user_send_ip", fakeRow{"user_id": params.Get("userid")}) {
+		ips = append(ips, ip["ip"].(string))
+	}
+	return ips, nil
+}
+
+// validateExternalIps accepts ips assigned to the user, or to the reseller of a subuser
+func (f *FakeApid) validateExternalIPs(params url.Values) (interface{}, error) {
+	user := f.first("user", fakeRow{"id": params.Get("userid")})
+	if user == nil {
+		return nil, fmt.Errorf("Invalid user id: %s", params.Get("userid"))
+	}
+
+	for _, address := range params["ip_list"] {
+		ip := f.first("external_ip", fakeRow{"ip": address})
+		if ip == nil || ip["reseller_id"] == 0 {
+			return nil, errors.New("One or more ips were invalid")
+		}
+		if !matches(ip, fakeRow{"reseller_id": user["id"]}) && !matches(ip, fakeRow{"reseller_id": user["reseller_id"]}) {
+			return nil, errors.New("One or more ips were invalid")
+		}
+	}
+	return len(params["ip_list"]), nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/sendgrid/chaos/adaptor/boss"
+)
+
+// FakeBoss is an in-process boss that serves the billing provider urls and subscriptions.
+// Like boss it puts the user on the package through apid, so paid accounts can be made offline
+type FakeBoss struct {
+	URL string
+
+	server *httptest.Server
+	token  string
+}
+
+var (
+	fakeSubscriptionPath  = regexp.MustCompile(`^/billing_provider_api/v2/accounts/(\d+)/subscriptions$`)
+	fakeUserPackagePath   = regexp.MustCompile(`^/billing_provider_api/v1/users/(\d+)/user_package$`)
+	fakeCancelPackagePath = regexp.MustCompile(`^/billing_provider_api/v1/users/(\d+)/user_package/cancel$`)
+	fakeCollectPath       = regexp.MustCompile(`^/billing_provider_api/v2/accounts/(\d+)/collect$`)
+)
+
+// startFakeBoss starts a fake boss that expects the auth token boss would get
+func startFakeBoss(token string) *FakeBoss {
+	f := &FakeBoss{token: token}
+	f.server = httptest.NewServer(f)
+	f.URL = f.server.URL
+	return f
+}
+
+func (f *FakeBoss) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/healthcheck" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Header.Get("Authorization") != "token="+f.token {
+		http.Error(w, "bad auth token", http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/billing_provider_api/v2/urls" && r.Method == "GET":
+		f.urls(w)
+	case fakeSubscriptionPath.MatchString(r.URL.Path) && r.Method == "POST":
+		f.subscribe(w, r, pathUserID(fakeSubscriptionPath, r.URL.Path))
+	case fakeUserPackagePath.MatchString(r.URL.Path) && r.Method == "PUT":
+		f.changePackage(w, r, pathUserID(fakeUserPackagePath, r.URL.Path))
+	case fakeCancelPackagePath.MatchString(r.URL.Path) && r.Method == "PUT",
+		fakeCollectPath.MatchString(r.URL.Path) && r.Method == "PUT":
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *FakeBoss) urls(w http.ResponseWriter) {
+	account := f.URL + "/billing_provider_api/v2/accounts/:account_id"
+	json.NewEncoder(w).Encode(boss.BillingProviderURLResponse{
+		AccountURL:              account,
+		AccountCollectURL:       account + "/collect",
+		AccountPaymentMethodURL: account + "/payment_methods",
+		AccountSubscriptionURL:  account + "/subscriptions",
+		PackagePreviewURL:       f.URL + "/billing_provider_api/v2/packages/:package_id/preview",
+	})
+}
+
+func (f *FakeBoss) subscribe(w http.ResponseWriter, r *http.Request, userID int) {
+	var params boss.BossSubscriptionParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if params.AccountID != userID {
+		http.Error(w, fmt.Sprintf("account id %d does not match the url", params.AccountID), http.StatusBadRequest)
+		return
+	}
+	if params.PaymentMethodID == "" {
+		http.Error(w, "payment_method_id is required", http.StatusBadRequest)
+		return
+	}
+	if params.Coupon != nil {
+		_, err = findCoupon(params.Coupon.CouponCode)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	adaptorErr := ApidAdaptor.SetUserPackage(userID, params.PackageID)
+	if adaptorErr != nil {
+		http.Error(w, adaptorErr.Error(), adaptorErr.SuggestedStatusCode)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(params)
+}
+
+func (f *FakeBoss) changePackage(w http.ResponseWriter, r *http.Request, userID int) {
+	var params boss.BossUpdatePackageParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// changes that take effect later are accepted and left for a billing run that never comes
+	today := time.Now().Format("2006-01-02")
+	if params.EffectiveDate <= today {
+		adaptorErr := ApidAdaptor.SetUserPackage(userID, params.PackageID)
+		if adaptorErr != nil {
+			http.Error(w, adaptorErr.Error(), adaptorErr.SuggestedStatusCode)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func pathUserID(path *regexp.Regexp, p string) int {
+	id, _ := strconv.Atoi(path.FindStringSubmatch(p)[1])
+	return id
+}
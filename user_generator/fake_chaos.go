@@ -0,0 +1,406 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	apidadaptor "github.com/sendgrid/chaos/adaptor/apid"
+	"github.com/sendgrid/chaos/client"
+	"github.com/sendgrid/go-apid"
+)
+
+// FakeChaos is an in-process chaos that signs users up and creates subusers through apid the way chaos does.
+// It hands out the session and authorization tokens and emails confirmations to -mail-sink
+type FakeChaos struct {
+	URL string
+
+	server     *httptest.Server
+	apidClient *apid.HTTPClient
+	apid       *apidadaptor.Adaptor
+
+	mutex         sync.Mutex
+	tokens        map[string]int
+	sessions      map[int]string
+	confirmations map[string]int
+}
+
+var fakeSubusersPath = regexp.MustCompile(`^/v1/users/(\d+)/subusers$`)
+
+// fakeSignupRequest is the body of signups and new subusers, only subusers have ips
+type fakeSignupRequest struct {
+	Username string   `json:"username"`
+	Email    string   `json:"email"`
+	Password string   `json:"password"`
+	IPs      []string `json:"ips"`
+}
+
+// startFakeChaos starts a fake chaos that talks to the apid at apidURL.
+// Like chaos, its apid requests are not throttled by -rps
+func startFakeChaos(apidURL string) *FakeChaos {
+	f := &FakeChaos{
+		apidClient:    apid.NewHTTPClient(apidURL),
+		tokens:        make(map[string]int),
+		sessions:      make(map[int]string),
+		confirmations: make(map[string]int),
+	}
+	f.apid = apidadaptor.New(f.apidClient)
+	f.server = httptest.NewServer(f)
+	f.URL = f.server.URL
+	return f
+}
+
+func (f *FakeChaos) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/healthcheck" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/v1/signup":
+		f.signup(w, r)
+	case fakeSubusersPath.MatchString(r.URL.Path):
+		f.createSubuser(w, r, pathUserID(fakeSubusersPath, r.URL.Path))
+	case r.URL.Path == sendConfirmationPath:
+		f.sendConfirmation(w, r)
+	case r.URL.Path == validateConfirmationPath:
+		f.validateConfirmation(w, r)
+	case r.URL.Path == accountConfirmationPath:
+		f.confirmAccount(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *FakeChaos) signup(w http.ResponseWriter, r *http.Request) {
+	request, ok := decodeSignup(w, r)
+	if !ok {
+		return
+	}
+
+	data := client.Signup{
+		Username:    request.Username,
+		Email:       request.Email,
+		Password:    request.Password,
+		IP:          makoIP(r),
+		FreePackage: f.apid.GetSignupPackageInfo(""),
+	}
+	if cookie, err := r.Cookie(BICookie); err == nil {
+		data.SignupBI = cookie.Value
+	}
+	if cookie, err := r.Cookie(PartnerCookie); err == nil {
+		data.SendGridPartner = cookie.Value
+	}
+
+	userID, adaptorErr := f.apid.CreateUser(data)
+	if adaptorErr != nil {
+		http.Error(w, adaptorErr.Error(), adaptorErr.SuggestedStatusCode)
+		return
+	}
+	data.UserID = userID
+
+	adaptorErr = f.apid.UpdateURLMailDomain(userID)
+	if adaptorErr != nil {
+		http.Error(w, adaptorErr.Error(), adaptorErr.SuggestedStatusCode)
+		return
+	}
+
+	for _, errs := range [][]error{
+		f.apid.AddUserPackage(data),
+		f.apid.AddIPGroup(data),
+		f.apid.AddUserProfile(data),
+		f.apid.AddCreditLimits(data),
+		f.apid.AddFilters(data),
+	} {
+		if len(errs) > 0 {
+			http.Error(w, errs[0].Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// chaos keeps the signup when the attribution cookies can not be stored
+	if data.SignupBI != "" {
+		f.apid.AddUserBI(data)
+	}
+	if data.SendGridPartner != "" {
+		f.apid.AddUserPartner(data)
+	}
+
+	writeCreated(w, SignupResponse{
+		Username: request.Username,
+		UserID:   userID,
+		Email:    request.Email,
+		SGToken:  f.newSession(userID),
+		Token:    f.authorize(userID),
+	})
+}
+
+// createSubuser creates the subuser sending from the ips, which have to be the reseller's
+func (f *FakeChaos) createSubuser(w http.ResponseWriter, r *http.Request, resellerID int) {
+	request, ok := decodeSignup(w, r)
+	if !ok {
+		return
+	}
+	if len(request.IPs) == 0 {
+		http.Error(w, "ips are required", http.StatusBadRequest)
+		return
+	}
+
+	_, adaptorErr := f.apid.GetUser(resellerID)
+	if adaptorErr != nil {
+		http.Error(w, adaptorErr.Error(), adaptorErr.SuggestedStatusCode)
+		return
+	}
+	_, adaptorErr = f.apid.ValidateIPs(resellerID, request.IPs)
+	if adaptorErr != nil {
+		http.Error(w, adaptorErr.Error(), adaptorErr.SuggestedStatusCode)
+		return
+	}
+
+	data := client.Signup{
+		Username:          request.Username,
+		Email:             request.Email,
+		Password:          request.Password,
+		IP:                makoIP(r),
+		ResellerID:        resellerID,
+		UserPackageStatus: apidadaptor.UserStatusSendGridPaidSubUser,
+		Active:            true,
+	}
+	userID, adaptorErr := f.apid.CreateUser(data)
+	if adaptorErr != nil {
+		http.Error(w, adaptorErr.Error(), adaptorErr.SuggestedStatusCode)
+		return
+	}
+	data.UserID = userID
+
+	for _, errs := range [][]error{f.apid.AddUserPackage(data), f.apid.AddUserProfile(data)} {
+		if len(errs) > 0 {
+			http.Error(w, errs[0].Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	for _, ip := range request.IPs {
+		_, adaptorErr = f.apid.AddUserSendIP(userID, ip)
+		if adaptorErr != nil {
+			http.Error(w, adaptorErr.Error(), adaptorErr.SuggestedStatusCode)
+			return
+		}
+	}
+
+	writeCreated(w, SignupResponse{
+		Username: request.Username,
+		UserID:   userID,
+		Email:    request.Email,
+		Token:    f.authorize(userID),
+	})
+}
+
+// sendConfirmation emails a confirmation link to the mail sink, the address has to be the user's
+func (f *FakeChaos) sendConfirmation(w http.ResponseWriter, r *http.Request) {
+	userID, ok := f.authorized(w, r)
+	if !ok {
+		return
+	}
+
+	var request client.SendConfirmation
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, adaptorErr := f.apid.GetUser(userID)
+	if adaptorErr != nil {
+		http.Error(w, adaptorErr.Error(), adaptorErr.SuggestedStatusCode)
+		return
+	}
+	if user.Email != request.Email {
+		http.Error(w, fmt.Sprintf("%s is not the email of user %d", request.Email, userID), http.StatusBadRequest)
+		return
+	}
+	if Conf.MailSink == "" {
+		http.Error(w, "there is no -mail-sink to send the confirmation to", http.StatusServiceUnavailable)
+		return
+	}
+
+	token := randomToken()
+	f.mutex.Lock()
+	f.confirmations[token] = userID
+	f.mutex.Unlock()
+
+	email, _ := json.Marshal(map[string]string{
+		"to":      user.Email,
+		"subject": "Confirm your email",
+		"html":    "https://app.sendgrid.com/confirm_email?token=" + token,
+	})
+	resp, err := http.Post("http://"+Conf.MailSink+"/", "application/json", bytes.NewBuffer(email))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	resp.Body.Close()
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (f *FakeChaos) validateConfirmation(w http.ResponseWriter, r *http.Request) {
+	userID, ok := f.authorized(w, r)
+	if !ok {
+		return
+	}
+
+	var request client.ValidateConfirmation
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mutex.Lock()
+	confirmed, found := f.confirmations[request.Token]
+	f.mutex.Unlock()
+	if !found || confirmed != userID {
+		http.Error(w, "invalid confirmation token", http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// confirmAccount activates the user and puts them on the package they picked.
+// Payment goes to boss in chaos, here the package is set directly
+func (f *FakeChaos) confirmAccount(w http.ResponseWriter, r *http.Request) {
+	userID, ok := f.authorized(w, r)
+	if !ok {
+		return
+	}
+
+	var request client.AccountConfirmation
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mutex.Lock()
+	session := f.sessions[userID]
+	f.mutex.Unlock()
+	if request.SGToken == "" || request.SGToken != session {
+		http.Error(w, "invalid signup session token", http.StatusBadRequest)
+		return
+	}
+
+	if request.CouponCode != "" {
+		coupon, adaptorErr := f.apid.CouponInfo(request.CouponCode)
+		if adaptorErr != nil || !coupon.Valid {
+			http.Error(w, fmt.Sprintf("invalid coupon %s", request.CouponCode), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if request.PackageUUID != "" {
+		packageID, adaptorErr := f.apid.PackageIDFromUUID(request.PackageUUID)
+		if adaptorErr == nil {
+			adaptorErr = f.apid.SetUserPackage(userID, packageID)
+		}
+		if adaptorErr != nil {
+			http.Error(w, adaptorErr.Error(), adaptorErr.SuggestedStatusCode)
+			return
+		}
+	}
+
+	var updated int
+	err = f.apidClient.DoFunction("setUserActive", url.Values{
+		"userid": []string{strconv.Itoa(userID)},
+		"active": []string{"1"},
+	}, &updated)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	adaptorErr := f.apid.ActivateUser(userID)
+	if adaptorErr != nil {
+		http.Error(w, adaptorErr.Error(), adaptorErr.SuggestedStatusCode)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (f *FakeChaos) newSession(userID int) string {
+	token := randomToken()
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.sessions[userID] = token
+	return token
+}
+
+func (f *FakeChaos) authorize(userID int) string {
+	token := randomToken()
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.tokens[token] = userID
+	return token
+}
+
+// authorized finds the user of the authorization token, answering 401 when there is none
+func (f *FakeChaos) authorized(w http.ResponseWriter, r *http.Request) (int, bool) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "token ")
+
+	f.mutex.Lock()
+	userID, ok := f.tokens[token]
+	f.mutex.Unlock()
+	if !ok {
+		http.Error(w, "bad authorization token", http.StatusUnauthorized)
+	}
+	return userID, ok
+}
+
+func decodeSignup(w http.ResponseWriter, r *http.Request) (fakeSignupRequest, bool) {
+	var request fakeSignupRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return request, false
+	}
+	if request.Username == "" || request.Email == "" || request.Password == "" {
+		http.Error(w, "username, email and password are required", http.StatusBadRequest)
+		return request, false
+	}
+	return request, true
+}
+
+// makoIP is the ip the user signed up from, mako puts it in a header
+func makoIP(r *http.Request) string {
+	var mako struct {
+		IP string `json:"ip"`
+	}
+	json.Unmarshal([]byte(r.Header.Get("X-Mako")), &mako)
+	return mako.IP
+}
+
+func writeCreated(w http.ResponseWriter, resp SignupResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func randomToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	apidadaptor "github.com/sendgrid/chaos/adaptor/apid"
+)
+
+// SignupFilterSet is the built in filter set with the filters and settings a chaos signup gives a user
+const SignupFilterSet = "signup"
+
+// FilterTemplate names a filter to enable with optional json settings
+type FilterTemplate struct {
+	Name     string          `json:"name"`
+	Settings json.RawMessage `json:"settings"`
+}
+
+// filterIDs maps the filter names chaos knows about to their ids
+var filterIDs = map[string]int{
+	apidadaptor.ClickTrackFilterName: apidadaptor.ClickTrackFilterID,
+	apidadaptor.OpenTrackFilterName:  apidadaptor.OpenTrackFilterID,
+	apidadaptor.DKIMFilterName:       apidadaptor.DKIMFilterID,
+	apidadaptor.DomainKeysFilterName: apidadaptor.DomainKeysFilterID,
+}
+
+// applyFilters enables each filter for the user and stores its settings
+func applyFilters(userID int, filters []FilterTemplate) error {
+	for _, f := range filters {
+		var rowCount int
+		err := ApidClient.DoFunction("enableUserFilter", url.Values{
+			"userid": []string{strconv.Itoa(userID)},
+			"type":   []string{f.Name},
+		}, &rowCount)
+		if err != nil && !strings.Contains(err.Error(), "already enabled for user") {
+			return fmt.Errorf("unable to enable filter %s: %s", f.Name, err.Error())
+		}
+
+		err = ApidClient.DoFunction("addUserFilters", url.Values{
+			"userid":   []string{strconv.Itoa(userID)},
+			"filterid": []string{strconv.Itoa(filterIDs[f.Name])},
+			"settings": []string{string(f.Settings)},
+		}, &rowCount)
+		if err != nil {
+			return fmt.Errorf("unable to add settings for filter %s: %s", f.Name, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// signupFilters mirrors the filters chaos adds in AddFilters
+var signupFilters = []FilterTemplate{
+	{Name: apidadaptor.DKIMFilterName, Settings: json.RawMessage(fmt.Sprintf(`{"domain": "%s", "use_from": 0}`, apidadaptor.DKIMDomain))},
+	{Name: apidadaptor.DomainKeysFilterName, Settings: json.RawMessage(fmt.Sprintf(`{"domain": "%s", "use_from": 0, "sender": 1}`, apidadaptor.DKIMDomain))},
+	{Name: apidadaptor.ClickTrackFilterName, Settings: json.RawMessage(`{"enable_text": 1}`)},
+	{Name: apidadaptor.OpenTrackFilterName},
+}
+
+// resolveFilters puts the filters of the named sets ahead of the template's own filters.
+// When a filter is named more than once the last one wins, so a template can override a set's settings
+func resolveFilters(sets map[string][]FilterTemplate, names []string, own []FilterTemplate) ([]FilterTemplate, error) {
+	all := make([]FilterTemplate, 0)
+	for _, name := range names {
+		set, ok := sets[name]
+		if !ok && name == SignupFilterSet {
+			set, ok = signupFilters, true
+		}
+		if !ok {
+			return nil, fmt.Errorf("unknown filter set %q", name)
+		}
+		all = append(all, set...)
+	}
+	all = append(all, own...)
+
+	position := make(map[string]int)
+	filters := make([]FilterTemplate, 0, len(all))
+	for _, f := range all {
+		if i, ok := position[f.Name]; ok {
+			filters[i] = f
+			continue
+		}
+		position[f.Name] = len(filters)
+		filters = append(filters, f)
+	}
+
+	return filters, nil
+}
+
+// verifyFilters reads back the user's filters and checks each one is there with its settings
+func verifyFilters(userID int, filters []FilterTemplate) error {
+	if len(filters) == 0 {
+		return nil
+	}
+
+	var rows []struct {
+		FilterID int    `json:"filter_id"`
+		Settings string `json:"settings"`
+	}
+	err := ApidClient.DoFunction("get", url.Values{
+		"tableName": []string{"user_filters"},
+		"where":     []string{fmt.Sprintf(`{"user_id" : "%d"}`, userID)},
+	}, &rows)
+	if err != nil {
+		return fmt.Errorf("unable to read back filters: %s", err.Error())
+	}
+
+	settings := make(map[int]string, len(rows))
+	for _, r := range rows {
+		settings[r.FilterID] = r.Settings
+	}
+
+	for _, f := range filters {
+		got, ok := settings[filterIDs[f.Name]]
+		if !ok {
+			return fmt.Errorf("filter %s is not enabled", f.Name)
+		}
+		if len(f.Settings) > 0 && !sameJSON(f.Settings, []byte(got)) {
+			return fmt.Errorf("filter %s has settings %s, want %s", f.Name, got, string(f.Settings))
+		}
+	}
+
+	return nil
+}
+
+// sameJSON reports if a and b hold the same json value whatever the formatting
+func sameJSON(a []byte, b []byte) bool {
+	var av, bv interface{}
+	if json.Unmarshal(a, &av) != nil || json.Unmarshal(b, &bv) != nil {
+		return false
+	}
+	return reflect.DeepEqual(av, bv)
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	apidadaptor "github.com/sendgrid/chaos/adaptor/apid"
+)
+
+// the ways an account can get its ips
+const (
+	// IPStrategyFirstIP assigns the first available ips under the first_ip policy, the way signup does
+	IPStrategyFirstIP = "first_ip"
+	// IPStrategySpecific assigns ips named in the scenario
+	IPStrategySpecific = "specific"
+	// IPStrategySharedPool leaves the account sending from the free ip group
+	IPStrategySharedPool = "shared_pool"
+)
+
+var ipStrategies = []string{IPStrategyFirstIP, IPStrategySpecific, IPStrategySharedPool}
+
+// IPOptions controls how the accounts of a template get their ips and which ip group they send from
+type IPOptions struct {
+	Strategy string `json:"strategy"`
+	// Specific are handed out in order, the template's ips to each account
+	Specific []string `json:"specific"`
+	// Group moves the accounts from the free ip group to this one
+	Group int `json:"group"`
+}
+
+func (o *IPOptions) strategy() string {
+	if o == nil || o.Strategy == "" {
+		return IPStrategyFirstIP
+	}
+	return o.Strategy
+}
+
+func (o *IPOptions) validate(t *AccountTemplate) error {
+	known := false
+	for _, s := range ipStrategies {
+		if o.strategy() == s {
+			known = true
+		}
+	}
+	if !known {
+		return fmt.Errorf("unknown ip strategy %q, known strategies are %s", o.Strategy, strings.Join(ipStrategies, ", "))
+	}
+	if o.Group < 0 {
+		return fmt.Errorf("ip group can not be negative")
+	}
+
+	switch o.strategy() {
+	case IPStrategySharedPool:
+		if t.IPs != 0 || o.Group != 0 {
+			return fmt.Errorf("%s accounts can not have ips or an ip group", IPStrategySharedPool)
+		}
+	case IPStrategySpecific:
+		if len(o.Specific) != t.Count*t.IPs {
+			return fmt.Errorf("%s needs count * ips = %d ips, got %d", IPStrategySpecific, t.Count*t.IPs, len(o.Specific))
+		}
+		seen := make(map[string]bool)
+		for _, ip := range o.Specific {
+			if net.ParseIP(ip) == nil {
+				return fmt.Errorf("%q is not an ip", ip)
+			}
+			if seen[ip] {
+				return fmt.Errorf("ip %s is listed more than once", ip)
+			}
+			seen[ip] = true
+		}
+	default:
+		if len(o.Specific) > 0 {
+			return fmt.Errorf("specific ips need the %s strategy", IPStrategySpecific)
+		}
+	}
+	return nil
+}
+
+// lookupIPs checks apid has every specific ip and nobody has taken it.
+// A resumed run may have taken them itself, assignIPs checks who has them then
+func (o *IPOptions) lookupIPs() error {
+	for _, ip := range o.Specific {
+		found, err := externalIP(ip)
+		if err != nil {
+			return err
+		}
+		if found == nil {
+			return fmt.Errorf("ip %s is not in external_ip", ip)
+		}
+		if found.ResellerID != 0 && !Resume {
+			return fmt.Errorf("ip %s is already assigned to user %d", ip, found.ResellerID)
+		}
+	}
+	return nil
+}
+
+// accountIPs returns the ips the account at position n of the template should get, nil unless they are specific
+func (o *IPOptions) accountIPs(t AccountTemplate, n int) []string {
+	if o.strategy() != IPStrategySpecific {
+		return nil
+	}
+	return o.Specific[n*t.IPs : (n+1)*t.IPs]
+}
+
+// setIPGroup takes accounts with dedicated ips out of the free ip group, into the template's group when it has one
+func setIPGroup(userID int, t AccountTemplate) error {
+	if t.IPAssignment.strategy() == IPStrategySharedPool {
+		return nil
+	}
+
+	if t.IPAssignment != nil && t.IPAssignment.Group > 0 {
+		adaptorErr := ApidAdaptor.SetUserIPGroup(userID, t.IPAssignment.Group, IPGroupFree)
+		if adaptorErr != nil {
+			return fmt.Errorf("unable to move to ip group %d: %s", t.IPAssignment.Group, adaptorErr.Error())
+		}
+		return nil
+	}
+
+	if t.IPs == 0 {
+		return nil
+	}
+
+	adaptorErr := ApidAdaptor.DeleteUserIPGroup(userID, IPGroupFree)
+	if adaptorErr != nil {
+		return adaptorErr
+	}
+	return nil
+}
+
+// assignIPs gives the account the rest of its ips, a resumed run may have assigned some already
+func assignIPs(userID int, t AccountTemplate, n int, resumed bool) error {
+	assigned := []string{}
+	if resumed {
+		// the last run may have died part way through assigning
+		ips, adaptorErr := ApidAdaptor.GetUserSendIps(userID)
+		if adaptorErr != nil {
+			return adaptorErr
+		}
+		assigned = ips
+	}
+
+	switch t.IPAssignment.strategy() {
+	case IPStrategyFirstIP:
+		for i := len(assigned); i < t.IPs; i++ {
+			// claimIP looks an ip up before it takes it, so it must not run in between
+			ipLock.Lock()
+			adaptorErr := ApidAdaptor.AssignFirstIP(userID)
+			ipLock.Unlock()
+			if adaptorErr != nil {
+				return adaptorErr
+			}
+		}
+	case IPStrategySpecific:
+		for _, ip := range t.IPAssignment.accountIPs(t, n) {
+			if contains(assigned, ip) {
+				continue
+			}
+			err := assignExternalIP(userID, ip)
+			if err != nil {
+				return err
+			}
+			_, adaptorErr := ApidAdaptor.AddUserSendIP(userID, ip)
+			if adaptorErr != nil {
+				return fmt.Errorf("unable to send from %s: %s", ip, adaptorErr.Error())
+			}
+		}
+	}
+
+	return verifyIPs(userID, t, n)
+}
+
+// assignExternalIP gives the user the ip when it is free or already theirs,
+// AssignExternalIP takes it from whoever has it and panics when apid does not have it
+func assignExternalIP(userID int, ip string) error {
+	ipLock.Lock()
+	defer ipLock.Unlock()
+
+	found, err := externalIP(ip)
+	if err != nil {
+		return err
+	}
+	if found == nil {
+		return fmt.Errorf("ip %s is not in external_ip", ip)
+	}
+	if found.ResellerID != 0 && found.ResellerID != userID {
+		return fmt.Errorf("ip %s is assigned to user %d", ip, found.ResellerID)
+	}
+
+	adaptorErr := ApidAdaptor.AssignExternalIP(userID, ip)
+	if adaptorErr != nil {
+		return fmt.Errorf("unable to assign %s: %s", ip, adaptorErr.Error())
+	}
+	return nil
+}
+
+// verifyIPs checks the user sends from the ips it should and that apid agrees they are the user's
+func verifyIPs(userID int, t AccountTemplate, n int) error {
+	ips, adaptorErr := ApidAdaptor.GetUserSendIps(userID)
+	if adaptorErr != nil {
+		return fmt.Errorf("unable to read back send ips: %s", adaptorErr.Error())
+	}
+	if len(ips) != t.IPs {
+		return fmt.Errorf("user should send from %d ips, got %v", t.IPs, ips)
+	}
+	for _, ip := range t.IPAssignment.accountIPs(t, n) {
+		if !contains(ips, ip) {
+			return fmt.Errorf("user should send from %s, got %v", ip, ips)
+		}
+	}
+	if len(ips) == 0 {
+		return nil
+	}
+
+	valid, adaptorErr := ApidAdaptor.ValidateIPs(userID, ips)
+	if adaptorErr != nil {
+		return fmt.Errorf("unable to validate ips %v: %s", ips, adaptorErr.Error())
+	}
+	if !valid {
+		return fmt.Errorf("ips %v are not valid for the user", ips)
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// externalIP looks the ip up in external_ip, it is nil when apid does not have it
+func externalIP(ip string) (*apidadaptor.ExternalIP, error) {
+	var found []apidadaptor.ExternalIP
+	err := ApidClient.DoFunction("getExternalIp", url.Values{
+		"ip":                  []string{ip},
+		"exclude_whitelabels": []string{"0"},
+	}, &found)
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, nil
+	}
+	return &found[0], nil
+}
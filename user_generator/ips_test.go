@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestLookupIPs(t *testing.T) {
+	startSimulation(t)
+
+	options := &IPOptions{Strategy: IPStrategySpecific, Specific: []string{"10.250.1.1", "10.250.1.2"}}
+	err := options.lookupIPs()
+	if err != nil {
+		t.Fatalf("free ips should be accepted, got %s", err.Error())
+	}
+
+	missing := &IPOptions{Strategy: IPStrategySpecific, Specific: []string{"10.250.1.1", "10.251.0.1"}}
+	if missing.lookupIPs() == nil {
+		t.Error("an ip apid does not have should be rejected")
+	}
+
+	adaptorErr := ApidAdaptor.AssignExternalIP(1, "10.250.1.2")
+	if adaptorErr != nil {
+		t.Fatalf("unable to assign: %s", adaptorErr.Error())
+	}
+	if options.lookupIPs() == nil {
+		t.Error("an ip assigned to a user should be rejected")
+	}
+
+	// the run being resumed may have taken it
+	Resume = true
+	defer func() { Resume = false }()
+	err = options.lookupIPs()
+	if err != nil {
+		t.Errorf("a resumed run should accept its own ips, got %s", err.Error())
+	}
+}
+
+func TestAssignExternalIP(t *testing.T) {
+	startSimulation(t)
+
+	err := assignExternalIP(1, "10.250.1.1")
+	if err != nil {
+		t.Fatalf("unable to assign a free ip: %s", err.Error())
+	}
+	err = assignExternalIP(1, "10.250.1.1")
+	if err != nil {
+		t.Errorf("assigning the user's own ip again should work, got %s", err.Error())
+	}
+	if assignExternalIP(2, "10.250.1.1") == nil {
+		t.Error("taking another user's ip should fail")
+	}
+	if assignExternalIP(2, "10.251.0.1") == nil {
+		t.Error("assigning an ip apid does not have should fail")
+	}
+
+	found, err := externalIP("10.250.1.1")
+	if err != nil || found == nil || found.ResellerID != 1 {
+		t.Errorf("got %v and %v, want the ip to stay with user 1", found, err)
+	}
+}
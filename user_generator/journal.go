@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// journal steps that are not stages
+const (
+	// StepRun is the first entry of the journal, it records the seed and run id of the run
+	StepRun = "run"
+	// StepPlanned is recorded before signup so a resumed run can find the account by its username
+	StepPlanned = "planned"
+	// StepSubuser is recorded for every subuser created under the account
+	StepSubuser = "subuser"
+	// StepDone is recorded once every stage of the account and its subusers succeeded
+	StepDone = "done"
+)
+
+// JournalEntry is one line of the journal, a step the account in a slot completed
+type JournalEntry struct {
+	Slot    string   `json:"slot"`
+	Step    string   `json:"step"`
+	Account *Account `json:"account"`
+	// Seed and RunID are only set on the StepRun entry
+	Seed  int64  `json:"seed,omitempty"`
+	RunID string `json:"run_id,omitempty"`
+}
+
+// JournalState is what a previous run finished for one slot
+type JournalState struct {
+	Account  *Account
+	Subusers []*Account
+	Done     map[string]bool
+}
+
+// Resumed reports if a previous run got as far as planning the account
+func (s *JournalState) Resumed() bool {
+	return s.Account != nil
+}
+
+// Journal records the steps completed for every account so an interrupted run can be resumed
+type Journal struct {
+	Path string
+	// Seed and RunID are the ones of the run that started the journal, they are empty for journals from before they were recorded
+	Seed  int64
+	RunID string
+
+	mutex   sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+	states  map[string]*JournalState
+}
+
+// openJournal creates a new journal starting with the seed and run id, or reads and appends to an existing one when resuming.
+// A new run refuses to replace a journal so an unfinished run is never lost, and a resumed one refuses another run's journal
+func openJournal(path string, resume bool) (*Journal, error) {
+	j := &Journal{
+		Path:   path,
+		states: make(map[string]*JournalState),
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if resume {
+		err := j.read()
+		if err != nil {
+			return nil, err
+		}
+		if j.RunID != "" && j.RunID != RunID {
+			return nil, fmt.Errorf("journal %s is of run %s with -seed %d, not run %s", path, j.RunID, j.Seed, RunID)
+		}
+		flags = os.O_WRONLY | os.O_APPEND
+	}
+
+	file, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, fmt.Errorf("journal %s already exists, use -resume to finish that run or remove it", path)
+		}
+		return nil, err
+	}
+	j.file = file
+	j.encoder = json.NewEncoder(file)
+
+	if resume {
+		err = j.endLine()
+	} else {
+		j.Seed, j.RunID = Seed, RunID
+		err = j.encoder.Encode(JournalEntry{Step: StepRun, Seed: Seed, RunID: RunID})
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// resumeSeed sets Seed to the one the journal was started with so the run is named the same way again.
+// An explicit -seed has to match it, journals from before the seed was recorded need one
+func resumeSeed(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var entry JournalEntry
+	scanner := bufio.NewScanner(file)
+	if scanner.Scan() {
+		json.Unmarshal(scanner.Bytes(), &entry)
+	}
+	if scanner.Err() != nil {
+		return scanner.Err()
+	}
+
+	switch {
+	case entry.Step != StepRun && Seed == 0:
+		return fmt.Errorf("journal %s does not record its seed, resume with the -seed it was started with", path)
+	case entry.Step != StepRun:
+		return nil
+	case Seed != 0 && Seed != entry.Seed:
+		return fmt.Errorf("journal %s was started with -seed %d, not %d", path, entry.Seed, Seed)
+	}
+
+	Seed = entry.Seed
+	return nil
+}
+
+// endLine finishes a line cut short by a run that died while writing it, so new entries start on their own line
+func (j *Journal) endLine() error {
+	info, err := j.file.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+
+	last := make([]byte, 1)
+	file, err := os.Open(j.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.ReadAt(last, info.Size()-1)
+	if err != nil {
+		return err
+	}
+	if last[0] == '\n' {
+		return nil
+	}
+
+	_, err = j.file.Write([]byte("\n"))
+	return err
+}
+
+func (j *Journal) read() error {
+	file, err := os.Open(j.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var entry JournalEntry
+		err := json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			// the last line is cut short when the run died writing it
+			fmt.Printf("skipping unreadable line %d of journal %s: %s\n", line, j.Path, err.Error())
+			continue
+		}
+		j.apply(entry)
+	}
+
+	return scanner.Err()
+}
+
+func (j *Journal) apply(entry JournalEntry) {
+	if entry.Step == StepRun {
+		j.Seed, j.RunID = entry.Seed, entry.RunID
+		return
+	}
+
+	state, ok := j.states[entry.Slot]
+	if !ok {
+		state = &JournalState{Done: make(map[string]bool)}
+		j.states[entry.Slot] = state
+	}
+
+	if entry.Step == StepSubuser {
+		state.Subusers = append(state.Subusers, entry.Account)
+		return
+	}
+
+	state.Account = entry.Account
+	state.Done[entry.Step] = true
+}
+
+// State returns what previous runs finished for the slot, it is empty for a new slot
+func (j *Journal) State(slot string) *JournalState {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	state, ok := j.states[slot]
+	if !ok {
+		return &JournalState{Done: make(map[string]bool)}
+	}
+
+	if state.Account != nil && !state.Done[StepDone] {
+		// failures are retried, they are recomputed by this run
+		state.Account.Failures = nil
+	}
+	return state
+}
+
+// Record appends a completed step for the account in the slot
+func (j *Journal) Record(slot string, step string, account *Account) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	return j.encoder.Encode(JournalEntry{Slot: slot, Step: step, Account: account})
+}
+
+// Close closes the journal file
+func (j *Journal) Close() error {
+	return j.file.Close()
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tempJournalPath is a journal path in a new temp dir, remove the dir when done
+func tempJournalPath(t *testing.T) (string, string) {
+	dir, err := ioutil.TempDir("", "user_generator")
+	if err != nil {
+		t.Fatalf("unable to make a temp dir: %s", err.Error())
+	}
+	return dir, filepath.Join(dir, "manifest.journal")
+}
+
+// startRun names the run the way setupNaming does for the seed
+func startRun(t *testing.T, seed int64) {
+	Seed = seed
+	UsernamePattern = DefaultUsernamePattern
+	EmailDomainPattern = DefaultEmailDomainPattern
+	PasswordPattern = DefaultPassword
+	err := setupNaming()
+	if err != nil {
+		t.Fatalf("unable to set up naming: %s", err.Error())
+	}
+}
+
+func TestResumeSeed(t *testing.T) {
+	dir, path := tempJournalPath(t)
+	defer os.RemoveAll(dir)
+
+	startRun(t, 1234)
+	runID := RunID
+	j, err := openJournal(path, false)
+	if err != nil {
+		t.Fatalf("unable to open journal: %s", err.Error())
+	}
+	j.Close()
+
+	// a resume without -seed takes the journal's
+	Seed = 0
+	err = resumeSeed(path)
+	if err != nil {
+		t.Fatalf("unable to resume: %s", err.Error())
+	}
+	if Seed != 1234 {
+		t.Fatalf("got seed %d, want 1234", Seed)
+	}
+	startRun(t, Seed)
+	if RunID != runID {
+		t.Errorf("got run id %s, want %s", RunID, runID)
+	}
+	j, err = openJournal(path, true)
+	if err != nil {
+		t.Fatalf("unable to resume journal: %s", err.Error())
+	}
+	j.Close()
+	if j.Seed != 1234 || j.RunID != runID {
+		t.Errorf("journal has seed %d and run %s, want 1234 and %s", j.Seed, j.RunID, runID)
+	}
+
+	// the same seed is fine, another one is refused
+	Seed = 1234
+	err = resumeSeed(path)
+	if err != nil {
+		t.Errorf("resuming with the journal's seed should work, got %s", err.Error())
+	}
+	Seed = 99
+	err = resumeSeed(path)
+	if err == nil {
+		t.Error("resuming with another seed should be refused")
+	}
+
+	startRun(t, 99)
+	_, err = openJournal(path, true)
+	if err == nil {
+		t.Error("opening the journal of another run should be refused")
+	}
+}
+
+func TestResumeSeedWithoutRunEntry(t *testing.T) {
+	dir, path := tempJournalPath(t)
+	defer os.RemoveAll(dir)
+
+	err := ioutil.WriteFile(path, []byte(`{"slot":"default/0","step":"planned","account":{"username":"testuser_1"}}`+"\n"), 0644)
+	if err != nil {
+		t.Fatalf("unable to write journal: %s", err.Error())
+	}
+
+	Seed = 0
+	err = resumeSeed(path)
+	if err == nil {
+		t.Error("a journal without its seed should need -seed")
+	}
+
+	Seed = 5
+	err = resumeSeed(path)
+	if err != nil || Seed != 5 {
+		t.Errorf("got seed %d and %v, want 5 and no error", Seed, err)
+	}
+}
+
+func TestJournalTornLine(t *testing.T) {
+	dir, path := tempJournalPath(t)
+	defer os.RemoveAll(dir)
+
+	startRun(t, 1234)
+	j, err := openJournal(path, false)
+	if err != nil {
+		t.Fatalf("unable to open journal: %s", err.Error())
+	}
+	account := &Account{UserID: 7, Username: "testuser_0", Failures: []StageError{{Stage: StageProfile}}}
+	for _, step := range []string{StepPlanned, StageSignup} {
+		err = j.Record("default/0", step, account)
+		if err != nil {
+			t.Fatalf("unable to record %s: %s", step, err.Error())
+		}
+	}
+	j.Close()
+
+	// the run died part way through writing the next entry
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("unable to open journal: %s", err.Error())
+	}
+	f.Write([]byte(`{"slot":"default/1","step":"plan`))
+	f.Close()
+
+	j, err = openJournal(path, true)
+	if err != nil {
+		t.Fatalf("unable to resume journal: %s", err.Error())
+	}
+	state := j.State("default/0")
+	if !state.Resumed() || state.Account.UserID != 7 || !state.Done[StageSignup] || state.Done[StageProfile] {
+		t.Errorf("got %+v for default/0, want user 7 signed up", state)
+	}
+	if len(state.Account.Failures) != 0 {
+		t.Errorf("failures of an unfinished account should be retried, got %v", state.Account.Failures)
+	}
+	if j.State("default/1").Resumed() {
+		t.Error("the torn entry for default/1 should be skipped")
+	}
+
+	err = j.Record("default/1", StepPlanned, &Account{UserID: 8, Username: "testuser_1"})
+	if err != nil {
+		t.Fatalf("unable to record: %s", err.Error())
+	}
+	j.Close()
+
+	// the new entry starts on a line of its own and is read back
+	j, err = openJournal(path, true)
+	if err != nil {
+		t.Fatalf("unable to resume journal again: %s", err.Error())
+	}
+	defer j.Close()
+	if state := j.State("default/1"); !state.Resumed() || state.Account.UserID != 8 {
+		t.Errorf("got %+v for default/1, want user 8 planned", state)
+	}
+	if !j.State("default/0").Done[StageSignup] {
+		t.Error("default/0 should still be signed up")
+	}
+}
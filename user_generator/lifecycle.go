@@ -0,0 +1,267 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"strings"
+
+	apidadaptor "github.com/sendgrid/chaos/adaptor/apid"
+)
+
+// the states an account can be left in other than active
+const (
+	StateDeactivated      = "deactivated"
+	StateDowngraded       = "downgraded"
+	StateSoftDeleted      = "soft_deleted"
+	StateOnHold           = "on_hold"
+	StateResellerDisabled = "reseller_disabled"
+)
+
+// UserHoldTable is where holds live, removeUserHold clears it and getUserHolds reads it
+const UserHoldTable = "user_hold"
+
+var lifecycleStates = []string{StateDeactivated, StateDowngraded, StateSoftDeleted, StateOnHold, StateResellerDisabled}
+
+// InHouseCompetitor is the competitor of users that build their own, apid ships with it vetted
+const InHouseCompetitor = "in house"
+
+// LifecycleOptions leaves the accounts of a template in a state other than active once everything else is set up
+type LifecycleOptions struct {
+	State string `json:"state"`
+	// Reasons are the churn reasons picked from for deactivated and downgraded accounts, any reason apid knows when empty
+	Reasons []string `json:"reasons"`
+	// Competitors are the providers deactivated accounts leave for, "in house" means they build their own.
+	// They have to be vetted ones, apid competitors without a user_id, the adaptor adds an unknown name
+	// for the first user that picks it and can not find it again for the next one. Any vetted one when empty
+	Competitors []string `json:"competitors"`
+	Comment     string   `json:"comment"`
+	// DowngradePackageID is the package downgraded accounts move to, the free package by default
+	DowngradePackageID int    `json:"downgrade_package_id"`
+	HoldReason         string `json:"hold_reason"`
+}
+
+func (o *LifecycleOptions) validate() error {
+	known := false
+	for _, state := range lifecycleStates {
+		if o.State == state {
+			known = true
+		}
+	}
+	if !known {
+		return fmt.Errorf("unknown lifecycle state %q, known states are %s", o.State, strings.Join(lifecycleStates, ", "))
+	}
+	if o.DowngradePackageID < 0 {
+		return errors.New("lifecycle downgrade_package_id can not be negative")
+	}
+	if o.DowngradePackageID == 0 {
+		o.DowngradePackageID = apidadaptor.FreePackageID
+	}
+	if o.HoldReason == "" {
+		o.HoldReason = "user_generator"
+	}
+	if o.State == StateDeactivated {
+		return o.vetCompetitors()
+	}
+	return nil
+}
+
+// vetCompetitors checks every competitor is a vetted one, and picks from all of them when there are none
+func (o *LifecycleOptions) vetCompetitors() error {
+	vetted, err := vettedCompetitors()
+	if err != nil {
+		return err
+	}
+	if len(vetted) == 0 {
+		return errors.New("apid has no vetted competitors")
+	}
+	if len(o.Competitors) == 0 {
+		o.Competitors = vetted
+		return nil
+	}
+
+	for _, c := range o.Competitors {
+		found := false
+		for _, v := range vetted {
+			if c == v {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("competitor %q is not vetted, vetted ones are %s", c, strings.Join(vetted, ", "))
+		}
+	}
+	return nil
+}
+
+// vettedCompetitors are the competitors the BI team has vetted, the ones without a user_id
+func vettedCompetitors() ([]string, error) {
+	var rows []struct {
+		Competitor string `json:"competitor"`
+	}
+	err := ApidClient.DoFunction("get", url.Values{
+		"tableName": []string{"competitors"},
+		"where":     []string{`{"user_id" : "0"}`},
+	}, &rows)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get competitors: %s", err.Error())
+	}
+
+	var vetted []string
+	for _, row := range rows {
+		// the adaptor puts the name in a json where clause
+		if !strings.ContainsAny(row.Competitor, `"\`) {
+			vetted = append(vetted, row.Competitor)
+		}
+	}
+	return vetted, nil
+}
+
+// applyLifecycle puts the account in the template's state the way the support and churn flows would
+func applyLifecycle(account *Account, options *LifecycleOptions, r *rand.Rand) error {
+	var err error
+	switch options.State {
+	case StateDeactivated:
+		err = deactivate(account.UserID, options, r)
+	case StateDowngraded:
+		err = downgradeToFree(account.UserID, options, r)
+	case StateSoftDeleted:
+		_, adaptorErr := ApidAdaptor.SoftDeleteUser(account.UserID)
+		if adaptorErr != nil {
+			err = fmt.Errorf("unable to soft delete: %s", adaptorErr.Error())
+		}
+	case StateOnHold:
+		err = putOnHold(account.UserID, options.HoldReason)
+	case StateResellerDisabled:
+		ok, adaptorErr := ApidAdaptor.Enable(account.UserID, false)
+		if adaptorErr != nil {
+			err = fmt.Errorf("unable to disable: %s", adaptorErr.Error())
+		} else if !ok {
+			err = errors.New("unable to disable: no user was updated")
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	account.State = options.State
+	return nil
+}
+
+// deactivate cancels the user's package and records why they left and where to
+func deactivate(userID int, options *LifecycleOptions, r *rand.Rand) error {
+	reason, err := churnReason(options, r)
+	if err != nil {
+		return err
+	}
+
+	// a resumed run finds the package cancelled when only the reason failed last time
+	status, err := packageStatus(userID)
+	if err != nil {
+		return err
+	}
+	if status != apidadaptor.PackageStatusPendingCancellation {
+		adaptorErr := ApidAdaptor.DeactivateUserPackage(userID)
+		if adaptorErr != nil {
+			return fmt.Errorf("unable to deactivate package: %s", adaptorErr.Error())
+		}
+	}
+
+	competitor := pick(r, options.Competitors)
+	inHouse := competitor == InHouseCompetitor
+	moving := r.Intn(2) == 0
+	adaptorErr := ApidAdaptor.InsertDeactivationReason(userID, reason, moving, inHouse, competitor, options.Comment)
+	if adaptorErr != nil {
+		return fmt.Errorf("unable to insert deactivation reason %q: %s", reason, adaptorErr.Error())
+	}
+
+	return verifyPackageStatus(userID, apidadaptor.PackageStatusPendingCancellation)
+}
+
+// downgradeToFree schedules the move to the downgrade package and records why
+func downgradeToFree(userID int, options *LifecycleOptions, r *rand.Rand) error {
+	reason, err := churnReason(options, r)
+	if err != nil {
+		return err
+	}
+
+	// like deactivate, the downgrade may have gone through before the reason failed
+	status, err := packageStatus(userID)
+	if err != nil {
+		return err
+	}
+	if status != apidadaptor.PackageStatusPendingDowngrade {
+		adaptorErr := ApidAdaptor.DowngradeUserPackage(userID, options.DowngradePackageID)
+		if adaptorErr != nil {
+			return fmt.Errorf("unable to downgrade to package %d: %s", options.DowngradePackageID, adaptorErr.Error())
+		}
+	}
+
+	adaptorErr := ApidAdaptor.InsertDowngradeToFreeReason(userID, reason)
+	if adaptorErr != nil {
+		return fmt.Errorf("unable to insert downgrade reason %q: %s", reason, adaptorErr.Error())
+	}
+
+	return verifyPackageStatus(userID, apidadaptor.PackageStatusPendingDowngrade)
+}
+
+// churnReason picks one of the template's reasons, or any reason apid knows about
+func churnReason(options *LifecycleOptions, r *rand.Rand) (string, error) {
+	if len(options.Reasons) > 0 {
+		return pick(r, options.Reasons), nil
+	}
+
+	reasons := ApidAdaptor.GetChurnReasons()
+	if len(reasons) == 0 {
+		return "", errors.New("apid has no churn reasons")
+	}
+	return reasons[r.Intn(len(reasons))].Reason, nil
+}
+
+// putOnHold adds a hold for the user and checks getUserHolds reports it
+func putOnHold(userID int, reason string) error {
+	columns := apidadaptor.NewCrudColumns()
+	columns.AddColumns(url.Values{
+		"user_id": []string{strconv.Itoa(userID)},
+		"reason":  []string{reason},
+	})
+
+	var ok string
+	err := ApidClient.DoFunction("add", url.Values{
+		"tableName": []string{UserHoldTable},
+		"values":    []string{columns.String()},
+	}, &ok)
+	if err != nil {
+		return fmt.Errorf("unable to add hold: %s", err.Error())
+	}
+
+	holds, adaptorErr := ApidAdaptor.GetUserHolds(userID)
+	if adaptorErr != nil {
+		return fmt.Errorf("unable to read back holds: %s", adaptorErr.Error())
+	}
+	if len(holds) == 0 {
+		return errors.New("user has no holds after adding one")
+	}
+	return nil
+}
+
+func packageStatus(userID int) (int, error) {
+	userPackage, adaptorErr := ApidAdaptor.GetUserPackage(userID)
+	if adaptorErr != nil {
+		return 0, fmt.Errorf("unable to read package: %s", adaptorErr.Error())
+	}
+	return userPackage.Status, nil
+}
+
+func verifyPackageStatus(userID int, want int) error {
+	status, err := packageStatus(userID)
+	if err != nil {
+		return err
+	}
+	if status != want {
+		return fmt.Errorf("package status should be %d, got %d", want, status)
+	}
+	return nil
+}
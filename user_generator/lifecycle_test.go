@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestVetCompetitors(t *testing.T) {
+	startSimulation(t)
+
+	options := &LifecycleOptions{State: StateDeactivated}
+	err := options.validate()
+	if err != nil {
+		t.Fatalf("unable to validate: %s", err.Error())
+	}
+	if len(options.Competitors) != 6 {
+		t.Errorf("got competitors %v, want the 6 vetted ones", options.Competitors)
+	}
+
+	for _, c := range []string{"Sendinblue", "Postmark"} {
+		options = &LifecycleOptions{State: StateDeactivated, Competitors: []string{"Mailgun", c}}
+		if options.validate() == nil {
+			t.Errorf("competitor %s should be rejected", c)
+		}
+	}
+
+	options = &LifecycleOptions{State: StateDeactivated, Competitors: []string{"Mailgun", InHouseCompetitor}}
+	err = options.validate()
+	if err != nil {
+		t.Errorf("vetted competitors should be accepted, got %s", err.Error())
+	}
+}
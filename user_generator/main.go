@@ -7,50 +7,289 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
-	"math/rand"
 	"net/http"
 	"net/url"
+	"os"
 	"strconv"
 	"sync"
-
-	"code.google.com/p/go-uuid/uuid"
+	"time"
 
 	apidadaptor "github.com/sendgrid/chaos/adaptor/apid"
+	"github.com/sendgrid/chaos/client"
 	"github.com/sendgrid/go-apid"
 )
 
-var TotalUsers, SubusersPerUser int
-var ChaosUrl, ApidUrl string
+var TotalUsers, SubusersPerUser, Concurrency int
+var ScenarioFile, ManifestPrefix, JournalPath string
+var Resume bool
 var ApidAdaptor *apidadaptor.Adaptor
 var ApidClient *apid.HTTPClient
-var ChaosPort = 50110
+
+// HTTPClient is shared by every chaos and apid request so they are throttled together
+var HTTPClient apid.HTTPRequester
+
+// RunJournal records the steps finished for every account in this run
+var RunJournal *Journal
 
 const (
 	IPGroupFree = 1
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "cleanup":
+			os.Exit(runCleanup(os.Args[2:]))
+		case "seed-ips":
+			os.Exit(runSeedIPs(os.Args[2:]))
+		case "check":
+			os.Exit(runCheck(os.Args[2:]))
+		}
+	}
+
 	flag.IntVar(&TotalUsers, "users", 1, "number of users")
 	flag.IntVar(&SubusersPerUser, "subusers", 1, "number of subusers to create per user")
-	flag.StringVar(&ChaosUrl, "chaos", "localhost", "chaos url")
-	flag.StringVar(&ApidUrl, "apid", "localhost", "apid url")
-	flag.Parse()
+	flag.IntVar(&Concurrency, "concurrency", 10, "number of users to create at the same time")
+	flag.BoolVar(&SkipChecks, "skip-checks", false, "skip the pre-flight checks of chaos, apid and the other dependencies")
+	registerConfigFlags(flag.CommandLine)
+	flag.StringVar(&ScenarioFile, "scenario", "", "json scenario file describing the accounts to generate, overrides -users and -subusers")
+	flag.StringVar(&ManifestPrefix, "manifest", "manifest", "path prefix for the json and csv manifests of the created accounts")
+	flag.StringVar(&JournalPath, "journal", "", "journal of the finished steps of every account, defaults to the manifest prefix with .journal")
+	flag.Int64Var(&Seed, "seed", 0, "seed for every generated value, the same seed and patterns give the same accounts, 0 picks one")
+	flag.StringVar(&UsernamePattern, "username", DefaultUsernamePattern, "text/template for usernames, see NameData for the fields")
+	flag.StringVar(&EmailDomainPattern, "email-domain", DefaultEmailDomainPattern, "text/template for the domain of the email, the local part is the username")
+	flag.StringVar(&PasswordPattern, "password", DefaultPassword, "text/template for passwords")
+	flag.BoolVar(&Resume, "resume", false, "finish the run recorded in the journal instead of starting a new one with its seed, use the same scenario")
+	err := parseFlags(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		fmt.Printf("oh no there's an error loading the config! %s\n", err.Error())
+		os.Exit(2)
+	}
 
-	ApidClient = apid.NewHTTPClient(fmt.Sprintf("http://%s:%d", ApidUrl, 8082))
-	ApidAdaptor = apidadaptor.New(ApidClient)
+	if JournalPath == "" {
+		JournalPath = ManifestPrefix + ".journal"
+	}
+	if Resume {
+		err = resumeSeed(JournalPath)
+		if err != nil {
+			fmt.Printf("oh no there's an error resuming the journal! %s\n", err.Error())
+			os.Exit(2)
+		}
+	}
+
+	err = setupNaming()
+	if err != nil {
+		fmt.Printf("oh no there's an error in the naming patterns! %s\n", err.Error())
+		os.Exit(2)
+	}
+
+	setupClients()
+
+	if !SkipChecks && !preflight(os.Stdout) {
+		os.Exit(1)
+	}
+
+	scenario := defaultScenario()
+	if ScenarioFile != "" {
+		scenario, err = loadScenario(ScenarioFile)
+		if err != nil {
+			fmt.Printf("oh no there's an error loading the scenario! %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	RunJournal, err = openJournal(JournalPath, Resume)
+	if err != nil {
+		fmt.Printf("oh no there's an error opening the journal! %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	if Concurrency < 1 {
+		Concurrency = 1
+	}
 
+	manifest := NewManifest()
+	jobs := make(chan job)
 	var wg sync.WaitGroup
 
-	for i := 0; i < TotalUsers; i++ {
+	for i := 0; i < Concurrency; i++ {
 		wg.Add(1)
 		go func() {
-			userID := createUserAndAssignIP()
-			createSubusers(userID)
-			wg.Done()
+			defer wg.Done()
+			for j := range jobs {
+				generateAccount(j, manifest)
+			}
 		}()
 	}
 
+	index := 0
+	for _, t := range scenario.Accounts {
+		for i := 0; i < t.Count; i++ {
+			jobs <- job{template: t, slot: fmt.Sprintf("%s/%d", t.Name, i), index: index, n: i}
+			index++
+		}
+	}
+	close(jobs)
+
 	wg.Wait()
+	RunJournal.Close()
+
+	err = manifest.Write(ManifestPrefix)
+	if err != nil {
+		fmt.Printf("oh no there's an error writing the manifest! %s\n", err.Error())
+		os.Exit(1)
+	}
+	fmt.Printf("wrote %d accounts to %s.json and %s.csv\n", len(manifest.Accounts), ManifestPrefix, ManifestPrefix)
+
+	failed := writeSummary(os.Stdout, manifest)
+	if failed > 0 {
+		fmt.Printf("oh no %d accounts failed! rerun with -resume to retry them\n", failed)
+		os.Exit(1)
+	}
+
+	// everything finished so there is nothing left to resume
+	err = os.Remove(JournalPath)
+	if err != nil {
+		fmt.Printf("oh no there's an error removing the journal! %s\n", err.Error())
+	}
+}
+
+// job is one account to generate, the slot names it in the journal,
+// the index is its position in the run and n its position in the template
+type job struct {
+	template AccountTemplate
+	slot     string
+	index    int
+	n        int
+}
+
+// setupClients builds the clients every command shares from the config.
+// Chaos and apid are called with a client of their own. The gandalf, authzd, crud alerts and boss adaptors
+// can not be given a client, so the default one gets the same timeouts and the gandalf and authzd headers
+func setupClients() {
+	if Conf.Simulate {
+		Conf.Apid = startFakeApid().URL
+		Conf.Chaos = startFakeChaos(Conf.Apid).URL
+		Conf.FakeBoss = true
+	}
+
+	timeout := time.Duration(Conf.Timeout) * time.Second
+	transport := newAuthTransport(newTransport(timeout))
+	transport.add(Conf.Chaos, Conf.ChaosAuth)
+	transport.add(Conf.Apid, Conf.ApidAuth)
+
+	HTTPClient = &throttledRequester{
+		throttle: NewThrottle(Conf.RPS),
+		client:   &http.Client{Transport: transport, Timeout: timeout},
+	}
+
+	adaptors := newAuthTransport(newTransport(timeout))
+	adaptors.add(Conf.Gandalf, Conf.GandalfAuth)
+	adaptors.add(Conf.Authzd, Conf.AuthzdAuth)
+	http.DefaultTransport = adaptors
+	http.DefaultClient.Timeout = timeout
+
+	ApidClient = apid.NewHTTPClient(Conf.Apid)
+	ApidClient.Client = HTTPClient
+	ApidAdaptor = apidadaptor.New(ApidClient)
+
+	if Conf.FakeBoss {
+		Conf.Boss = startFakeBoss(Conf.BossToken).URL
+	}
+	setupBoss()
+
+	err := setupCrudAlerts()
+	if err != nil {
+		fmt.Printf("oh no there's an error setting up crud alerts! %s\n", err.Error())
+		os.Exit(2)
+	}
+
+	err = setupAuthzd()
+	if err != nil {
+		fmt.Printf("oh no there's an error setting up authzd! %s\n", err.Error())
+		os.Exit(2)
+	}
+
+	err = setupGandalf()
+	if err != nil {
+		fmt.Printf("oh no there's an error setting up gandalf! %s\n", err.Error())
+		os.Exit(2)
+	}
+
+	if Conf.MailSink != "" {
+		MailSink, err = startConfirmationSink(Conf.MailSink)
+		if err != nil {
+			fmt.Printf("oh no there's an error starting the mail sink! %s\n", err.Error())
+			os.Exit(2)
+		}
+	}
+}
+
+// generateAccount creates one account from the template along with its subusers.
+// The slot names the account in the journal so a resumed run picks up where the last one stopped
+func generateAccount(j job, manifest *Manifest) {
+	t, slot := j.template, j.slot
+	state := RunJournal.State(slot)
+	if state.Done[StepDone] {
+		manifest.Add(state.Account)
+		manifest.Add(state.Subusers...)
+		return
+	}
+
+	account := createUserAndAssignIP(j, state)
+	manifest.Add(account)
+	manifest.Add(state.Subusers...)
+	if account.Failed() {
+		return
+	}
+
+	if t.Subusers > 0 && !state.Done[StageSubusers] {
+		subusers, err := createSubusers(account, t.Subusers, j, state)
+		manifest.Add(subusers...)
+		if err != nil {
+			account.fail(StageSubusers, err)
+			return
+		}
+		if !journal(account, slot, StageSubusers) {
+			return
+		}
+	}
+
+	// the state comes last since most of them stop the account from being changed
+	if !t.IsActive() && !state.Done[StageDeactivate] {
+		err := setUserActive(account.UserID, false)
+		if err != nil {
+			account.fail(StageDeactivate, err)
+			return
+		}
+		if !journal(account, slot, StageDeactivate) {
+			return
+		}
+	}
+
+	if t.Lifecycle != nil && !state.Done[StageLifecycle] {
+		err := applyLifecycle(account, t.Lifecycle, accountRand(fmt.Sprintf("lifecycle%d", j.index)))
+		if err != nil {
+			account.fail(StageLifecycle, err)
+			return
+		}
+		if !journal(account, slot, StageLifecycle) {
+			return
+		}
+	}
+
+	journal(account, slot, StepDone)
+}
+
+// journal records the completed step for the account and reports if that worked.
+// Without the record a resumed run would repeat the step, so failing to write it fails the account
+func journal(account *Account, slot string, step string) bool {
+	err := RunJournal.Record(slot, step, account)
+	if err != nil {
+		account.fail(StageJournal, err)
+		return false
+	}
+	return true
 }
 
 type SignupResponse struct {
@@ -68,102 +307,418 @@ type CreditAllocation struct {
 
 type CreditAllocationType string
 
-func createUserAndAssignIP() int {
-	resp, err := createUser()
-	fmt.Println("user created!")
+// createUserAndAssignIP creates a user described by the account template and returns it for the manifest.
+// A failure in signup, activation, ip or package setup stops the rest of the setup.
+// Steps the journal says are done are skipped
+func createUserAndAssignIP(j job, state *JournalState) *Account {
+	t, slot := j.template, j.slot
+	account := state.Account
+	if account == nil {
+		var err error
+		account, err = newAccount(NameData{Prefix: "testuser", Template: t.Name, RunID: RunID, Index: j.index})
+		if err != nil {
+			// there is no account to record the failure on yet
+			account = &Account{Template: t.Name, IPs: []string{}}
+			account.fail(StageSignup, err)
+			return account
+		}
+		account.Template = t.Name
+		account.PackageID = t.PackageID
+		if !journal(account, slot, StepPlanned) {
+			return account
+		}
+	}
 
-	if err != nil {
-		fmt.Printf("oh no there's an error! %s\n", err.Error())
+	if !state.Done[StageSignup] {
+		cookies, err := signupCookies(t.Cookies, accountRand(fmt.Sprintf("cookies%d", j.index)))
+		if err == nil {
+			err = signup(account, state.Resumed(), cookies)
+		}
+		if err != nil {
+			account.fail(StageSignup, err)
+			return account
+		}
+		if !journal(account, slot, StageSignup) {
+			return account
+		}
 	}
+
+	if !state.Done[StageActivate] {
+		var err error
+		if t.ConfirmEmail {
+			err = confirmEmail(account, t)
+		} else {
+			err = setUserActive(account.UserID, true)
+		}
+		if err != nil {
+			account.fail(StageActivate, err)
+			return account
+		}
+		if !journal(account, slot, StageActivate) {
+			return account
+		}
+	}
+
+	if !state.Done[StageIPGroup] {
+		err := setIPGroup(account.UserID, t)
+		if err != nil {
+			account.fail(StageIPGroup, err)
+			return account
+		}
+		if !journal(account, slot, StageIPGroup) {
+			return account
+		}
+	}
+
 	//set user package
-	err = ApidAdaptor.DeleteUserIPGroup(resp.UserID, IPGroupFree)
-	if err != nil {
-		return 0
+	if !state.Done[StagePackage] {
+		adaptorErr := ApidAdaptor.SetUserPackage(account.UserID, t.PackageID)
+		if adaptorErr != nil {
+			account.fail(StagePackage, adaptorErr)
+			return account
+		}
+		if !journal(account, slot, StagePackage) {
+			return account
+		}
+	}
+
+	if t.Billing != nil && !state.Done[StageSubscribe] {
+		err := subscribe(account.UserID, t.Billing)
+		if err != nil {
+			account.fail(StageSubscribe, err)
+			return account
+		}
+		account.PackageID = t.Billing.PackageID
+		account.Coupon = t.Billing.Coupon
+		if t.Billing.ChangePackageID != 0 && t.Billing.ChangeImmediately {
+			account.PackageID = t.Billing.ChangePackageID
+		}
+		if !journal(account, slot, StageSubscribe) {
+			return account
+		}
+	}
+
+	if !state.Done[StageIPAssign] {
+		err := assignIPs(account.UserID, t, j.n, state.Resumed())
+		if err != nil {
+			account.fail(StageIPAssign, err)
+			return account
+		}
+		if !journal(account, slot, StageIPAssign) {
+			return account
+		}
+	}
+
+	if !state.Done[StageCreditLimits] {
+		err := applyCreditLimit(t, account.UserID)
+		if err != nil {
+			account.fail(StageCreditLimits, err)
+		} else {
+			journal(account, slot, StageCreditLimits)
+		}
+	}
+
+	if !state.Done[StageProfile] {
+		profile := profileFor(t, j.index)
+		if *profile != (client.UserProfile{}) {
+			profile.UserID = account.UserID
+			_, adaptorErr := ApidAdaptor.EditUserProfile(profile)
+			if adaptorErr != nil {
+				account.fail(StageProfile, adaptorErr)
+			} else {
+				journal(account, slot, StageProfile)
+			}
+		}
+	}
+
+	if !t.Provision.skip() && !state.Done[StageProvision] {
+		err := provisionUser(account.UserID, t.Provision, profileFor(t, j.index), accountRand(fmt.Sprintf("provision%d", j.index)))
+		if err != nil {
+			account.fail(StageProvision, err)
+		} else {
+			journal(account, slot, StageProvision)
+		}
+	}
+
+	if !state.Done[StageFilters] {
+		err := applyFilters(account.UserID, t.Filters)
+		if err == nil {
+			err = verifyFilters(account.UserID, t.Filters)
+		}
+		if err != nil {
+			account.fail(StageFilters, err)
+		} else {
+			journal(account, slot, StageFilters)
+		}
+	}
+
+	if t.BounceManagement && !state.Done[StageBounces] {
+		err := addBounceManagement(account.UserID)
+		if err != nil {
+			account.fail(StageBounces, err)
+		} else {
+			journal(account, slot, StageBounces)
+		}
+	}
+
+	if t.UsageAlerts && !state.Done[StageAlerts] {
+		err := setUsageAlerts(account)
+		if err != nil {
+			account.fail(StageAlerts, err)
+		} else {
+			journal(account, slot, StageAlerts)
+		}
 	}
 
-	ApidAdaptor.SetUserPackage(resp.UserID, 11)
+	if !t.Scopes.skip() && !state.Done[StageScopes] {
+		scopeSetID, err := createScopeSet(account, t.Scopes, state.Resumed())
+		account.ScopeSetID = scopeSetID
+		if err != nil {
+			account.fail(StageScopes, err)
+		} else {
+			journal(account, slot, StageScopes)
+		}
+	}
+
+	// inactive users can not log in, so this has to happen before they are deactivated
+	if GandalfAdaptor != nil && !state.Done[StageAuth] {
+		err := authenticate(account)
+		if err != nil {
+			account.fail(StageAuth, err)
+		} else {
+			journal(account, slot, StageAuth)
+		}
+	}
 
-	// get the first available IP and immediately assign it to the user
-	adaptorErr := ApidAdaptor.AssignFirstIP(resp.UserID)
+	ips, adaptorErr := ApidAdaptor.GetUserSendIps(account.UserID)
 	if adaptorErr != nil {
-		return 0
+		account.fail(StageIPAssign, adaptorErr)
+	}
+	// apid answers null for a user without send ips, the manifest wants []
+	if ips != nil {
+		account.IPs = ips
 	}
 
-	return resp.UserID
+	return account
+}
 
+// profileFor returns the template's fixed profile or generates one for the account at index
+func profileFor(t AccountTemplate, index int) *client.UserProfile {
+	if t.Profile != nil {
+		profile := *t.Profile
+		return &profile
+	}
+	return generateProfile(t.GeneratedProfile, accountRand(fmt.Sprintf("profile%d", index)))
 }
 
-var SteadfastLocationId = 5
+// signup creates the account through chaos.
+// When resuming, the last run may have signed it up without recording it, so look it up first
+func signup(account *Account, resumed bool, cookies []*http.Cookie) error {
+	if resumed {
+		user, adaptorErr := ApidAdaptor.GetUserByUsername(account.Username)
+		if adaptorErr == nil {
+			account.UserID = user.ID
+			fmt.Printf("found user %s from the last run\n", account.Username)
+			return nil
+		}
+		if adaptorErr.SuggestedStatusCode != http.StatusNotFound {
+			return fmt.Errorf("unable to look up user %s: %s", account.Username, adaptorErr.Error())
+		}
+	}
 
-func generateRandomIP() string {
-	ip := fmt.Sprintf("192.168.%d.%d", rand.Intn(256), rand.Intn(256))
-	return ip
+	_, err := createUser(account, cookies)
+	if err != nil {
+		return err
+	}
+	fmt.Println("user created!")
+	return nil
 }
 
-func generateNewIP() error {
+// createSubusers creates count subusers under the given reseller through chaos.
+// Each subuser gets its own IP which is first assigned to the reseller so chaos will accept it.
+// When resuming, only the subusers the reseller is missing are created
+func createSubusers(reseller *Account, count int, j job, state *JournalState) ([]*Account, error) {
+	resellerID := reseller.UserID
+	subusers := make([]*Account, 0, count)
+
+	existing := 0
+	if state.Resumed() {
+		subuserIDs, adaptorErr := ApidAdaptor.GetSubuserIDs(resellerID)
+		if adaptorErr != nil {
+			return subusers, fmt.Errorf("unable to get subuser ids for reseller %d: %s", resellerID, adaptorErr.Error())
+		}
+		existing = len(subuserIDs)
+	}
+
+	for i := existing; i < count; i++ {
+		subuser, err := createSubuser(resellerID, NameData{
+			Prefix:   "testsubuser",
+			Template: reseller.Template,
+			RunID:    RunID,
+			Index:    j.index,
+			Subuser:  i + 1,
+		})
+		if subuser != nil {
+			subuser.Template = reseller.Template
+			if subuser.UserID != 0 && GandalfAdaptor != nil {
+				authErr := authenticate(subuser)
+				if authErr != nil {
+					subuser.fail(StageAuth, authErr)
+				}
+			}
+			subusers = append(subusers, subuser)
+			if subuser.UserID != 0 {
+				journalErr := RunJournal.Record(j.slot, StepSubuser, subuser)
+				if journalErr != nil {
+					return subusers, fmt.Errorf("unable to journal subuser %d: %s", subuser.UserID, journalErr.Error())
+				}
+			}
+		}
+		if err != nil {
+			return subusers, err
+		}
+	}
+
+	subuserIDs, adaptorErr := ApidAdaptor.GetSubuserIDs(resellerID)
+	if adaptorErr != nil {
+		return subusers, fmt.Errorf("unable to get subuser ids for reseller %d: %s", resellerID, adaptorErr.Error())
+	}
+
+	subuserCount, adaptorErr := ApidAdaptor.CountSubusers(&client.SubuserRequest{UserID: resellerID})
+	if adaptorErr != nil {
+		return subusers, fmt.Errorf("unable to count subusers for reseller %d: %s", resellerID, adaptorErr.Error())
+	}
+
+	if len(subuserIDs) != count || subuserCount != count {
+		return subusers, fmt.Errorf("reseller %d should have %d subusers, got %d ids and a count of %d", resellerID, count, len(subuserIDs), subuserCount)
+	}
 
-	defaultLocation := SteadfastLocationId
-	var serverNameId int
-	testIP := generateRandomIP()
+	return subusers, nil
+}
 
-	// the process of selecting an ip for the user is as follows:
-	// 1. get a list of locations from the ip_assignment_policy table
-	// 2. get an ip based from those locations
-	// we need to add a server for a given location, as well as an external ip to live on that server so
-	// we can "find" that ip to assign
-	var newServerLocationID int
-	ApidClient.DoFunction("executeSql", url.Values{
-		"query":    []string{fmt.Sprintf(`insert into server_location (id,name) values(%d,"test")`, defaultLocation)},
-		"rw":       []string{"1"},
-		"resource": []string{"mail"},
-		"insert":   []string{"1"},
-	}, &newServerLocationID)
+// ipLock keeps concurrent workers from claiming the same available ip
+var ipLock sync.Mutex
 
-	ApidClient.DoFunction("addServerName", url.Values{
-		"ip":       []string{generateRandomIP()},
-		"server":   []string{"testservername"},
-		"type":     []string{"proxy"},
-		"location": []string{strconv.Itoa(defaultLocation)},
-	}, &serverNameId)
+// claimIP finds an available ip and assigns it to the reseller as an external ip
+func claimIP(resellerID int) (string, error) {
+	ipLock.Lock()
+	defer ipLock.Unlock()
 
-	var setPolicySuccess int
-	ApidClient.DoFunction("addAssignmentPolicy", url.Values{
-		"policy":   []string{"first_ip"},
-		"location": []string{strconv.Itoa(defaultLocation)},
-	}, setPolicySuccess)
+	ip, adaptorErr := ApidAdaptor.GetFirstIP()
+	if adaptorErr != nil {
+		return "", adaptorErr
+	}
 
-	var addExternalIpSuccess int
-	addIPErr := ApidClient.DoFunction("addExternalIp", url.Values{
-		"ip":             []string{testIP},
-		"server_name_id": []string{strconv.Itoa(serverNameId)},
-	}, &addExternalIpSuccess)
-	if addIPErr != nil && addIPErr.Error() != `"key exists"` {
-		return addIPErr
+	adaptorErr = ApidAdaptor.AssignExternalIP(resellerID, ip)
+	if adaptorErr != nil {
+		return "", adaptorErr
 	}
 
-	return nil
+	return ip, nil
 }
 
-func createSubusers(resellerID int) error {
-	/*	createSubuserURL := fmt.Sprintf("http://%s:%d/v1/users/%d/subusers", ChaosUrl, ChaosPort, resellerID)
+// createSubuser creates a single subuser with its own ip through the chaos subuser endpoint
+func createSubuser(resellerID int, data NameData) (*Account, error) {
+	subuser, err := newAccount(data)
+	if err != nil {
+		return nil, err
+	}
+	subuser.ResellerID = resellerID
 
-		return nil
-	*/
-	return nil
+	ip, err := claimIP(resellerID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to claim ip for subuser of reseller %d: %s", resellerID, err.Error())
+	}
+
+	createSubuserURL := fmt.Sprintf("%s/v1/users/%d/subusers", Conf.Chaos, resellerID)
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"username": subuser.Username,
+		"email":    subuser.Email,
+		"password": subuser.Password,
+		"ips":      []string{ip},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", createSubuserURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `application/json`)
+	req.Header.Set("X-Mako", `{"ip":"192.168.1.700"}`)
+
+	createResponse, err := HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer createResponse.Body.Close()
+
+	if createResponse.StatusCode != http.StatusCreated {
+		b, _ := ioutil.ReadAll(createResponse.Body)
+		errStr := "got status %d creating subuser. response: %s "
+		errStr += "curl -v %s -d '%s'"
+		return nil, fmt.Errorf(errStr, createResponse.StatusCode, string(b), createSubuserURL, string(jsonData))
+	}
+
+	var resp SignupResponse
+	err = json.NewDecoder(createResponse.Body).Decode(&resp)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.UserID == 0 {
+		errStr := "unhandled error creating new subuser. "
+		errStr += "curl -v %s -d '%s'; got status: %d, No ID. %#v"
+		return nil, fmt.Errorf(errStr, createSubuserURL, string(jsonData), createResponse.StatusCode, resp)
+	}
+	subuser.UserID = resp.UserID
+	subuser.Token = resp.Token
+
+	// make sure the claimed ip is one of the subuser's send ips
+	sendIPs, adaptorErr := ApidAdaptor.GetUserSendIps(subuser.UserID)
+	if adaptorErr != nil {
+		return subuser, fmt.Errorf("unable to get send ips for subuser %d: %s", subuser.UserID, adaptorErr.Error())
+	}
+	subuser.IPs = sendIPs
+	for _, sendIP := range sendIPs {
+		if sendIP == ip {
+			return subuser, nil
+		}
+	}
+
+	_, adaptorErr = ApidAdaptor.AddUserSendIP(subuser.UserID, ip)
+	if adaptorErr != nil {
+		return subuser, fmt.Errorf("unable to add send ip %s for subuser %d: %s", ip, subuser.UserID, adaptorErr.Error())
+	}
+	subuser.IPs = append(subuser.IPs, ip)
+
+	return subuser, nil
 }
 
-func createUser() (SignupResponse, error) {
-	username := fmt.Sprintf("testuser_%s", uuid.New())
-	email := fmt.Sprintf("testuser_%s@sendgrid.com", uuid.New())
-	password := "very secure password 1"
-	return createSpecificUser(username, email, password)
+// createUser signs up the account and records the ids and tokens chaos hands back
+func createUser(account *Account, cookies []*http.Cookie) (SignupResponse, error) {
+	resp, err := createSpecificUser(account.Username, account.Email, account.Password, cookies...)
+	account.UserID = resp.UserID
+	account.Token = resp.Token
+	account.SGToken = resp.SGToken
+	return resp, err
 }
 
-// createUser is a helper method to create a user assuming username, email, and password are valid
-func createSpecificUser(username string, email string, password string) (SignupResponse, error) {
-	createUserURL := fmt.Sprintf("http://%s:%d/v1/signup", ChaosUrl, ChaosPort)
-	var jsonData = []byte(fmt.Sprintf(`{"username":"%s", "email":"%s", "password":"%s"}`, username, email, password))
+// createUser is a helper method to create a user assuming username, email, and password are valid.
+// The cookies are sent the way a browser coming from the marketing site would
+func createSpecificUser(username string, email string, password string, cookies ...*http.Cookie) (SignupResponse, error) {
+	createUserURL := Conf.Chaos + "/v1/signup"
 	var resp SignupResponse
+	// the values come from the patterns so they are escaped
+	jsonData, err := json.Marshal(map[string]string{
+		"username": username,
+		"email":    email,
+		"password": password,
+	})
+	if err != nil {
+		return resp, err
+	}
 
 	req, err := http.NewRequest("POST", createUserURL, bytes.NewBuffer(jsonData))
 	if err != nil {
@@ -171,9 +726,11 @@ func createSpecificUser(username string, email string, password string) (SignupR
 	}
 	req.Header.Set("Content-Type", `application/json`)
 	req.Header.Set("X-Mako", `{"ip":"192.168.1.700"}`)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
 
-	httpClient := &http.Client{}
-	createResponse, err := httpClient.Do(req)
+	createResponse, err := HTTPClient.Do(req)
 	if err != nil {
 		return resp, err
 	}
@@ -197,25 +754,24 @@ func createSpecificUser(username string, email string, password string) (SignupR
 		return resp, fmt.Errorf(errStr, createUserURL, string(jsonData), createResponse.StatusCode, resp)
 	}
 
-	err = setUserToActive(resp.UserID)
-	if err != nil {
-		return resp, err
-	}
-
 	return resp, nil
 }
 
-func setUserToActive(userID int) error {
-	ApidClient := apid.NewHTTPClient(ApidUrl)
-
+func setUserActive(userID int, active bool) error {
 	params := url.Values{
 		"userid": []string{strconv.Itoa(userID)},
 		"active": []string{strconv.Itoa(1)},
 	}
+	if !active {
+		params.Set("active", strconv.Itoa(0))
+	}
 
 	var updated int
 	err := ApidClient.DoFunction("setUserActive", params, &updated)
 	if err != nil {
+		if !active {
+			return errors.New("unable to deactivate parent")
+		}
 		return errors.New("unable to activate parent")
 	}
 
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultPassword = "very secure password 1"
+)
+
+// Account is everything downstream tools need to know about a generated parent user or subuser
+type Account struct {
+	UserID     int      `json:"user_id"`
+	ResellerID int      `json:"reseller_id,omitempty"`
+	Template   string   `json:"template"`
+	Username   string   `json:"username"`
+	Email      string   `json:"email"`
+	Password   string   `json:"password"`
+	PackageID  int      `json:"package_id,omitempty"`
+	Coupon     string   `json:"coupon,omitempty"`
+	IPs        []string `json:"ips"`
+	Token      string   `json:"token,omitempty"`
+	SGToken    string   `json:"signup_session_token,omitempty"`
+	ScopeSetID string   `json:"scope_set_id,omitempty"`
+	// State is the lifecycle state the account was left in, empty when it is active
+	State string `json:"state,omitempty"`
+
+	Failures []StageError `json:"failures,omitempty"`
+}
+
+// Manifest records every account a run created
+type Manifest struct {
+	CreatedAt time.Time  `json:"created_at"`
+	RunID     string     `json:"run_id"`
+	Seed      int64      `json:"seed"`
+	Accounts  []*Account `json:"accounts"`
+
+	mutex sync.Mutex
+}
+
+func NewManifest() *Manifest {
+	return &Manifest{
+		CreatedAt: time.Now(),
+		RunID:     RunID,
+		Seed:      Seed,
+		Accounts:  make([]*Account, 0),
+	}
+}
+
+// Add records accounts, it is safe to call from multiple goroutines
+func (m *Manifest) Add(accounts ...*Account) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.Accounts = append(m.Accounts, accounts...)
+}
+
+// Write writes the manifest as <prefix>.json and <prefix>.csv
+func (m *Manifest) Write(prefix string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	err := m.writeJSON(prefix + ".json")
+	if err != nil {
+		return err
+	}
+
+	return m.writeCSV(prefix + ".csv")
+}
+
+func (m *Manifest) writeJSON(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	return err
+}
+
+func (m *Manifest) writeCSV(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"user_id", "reseller_id", "template", "username", "email", "password", "package_id", "coupon", "ips", "token", "signup_session_token", "scope_set_id", "state", "failures"})
+	for _, a := range m.Accounts {
+		failures := make([]string, len(a.Failures))
+		for i, f := range a.Failures {
+			failures[i] = f.Error()
+		}
+
+		w.Write([]string{
+			strconv.Itoa(a.UserID),
+			strconv.Itoa(a.ResellerID),
+			a.Template,
+			a.Username,
+			a.Email,
+			a.Password,
+			strconv.Itoa(a.PackageID),
+			a.Coupon,
+			strings.Join(a.IPs, " "),
+			a.Token,
+			a.SGToken,
+			a.ScopeSetID,
+			a.State,
+			strings.Join(failures, "; "),
+		})
+	}
+	w.Flush()
+
+	return w.Error()
+}
+
+// readManifest loads a manifest written by a previous run
+func readManifest(path string) (*Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m := NewManifest()
+	err = json.NewDecoder(f).Decode(m)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse manifest %s: %s", path, err.Error())
+	}
+
+	return m, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"strings"
+	"text/template"
+	"time"
+
+	"code.google.com/p/go-uuid/uuid"
+)
+
+// default patterns, the username is unique within a run and the run id keeps runs apart
+const (
+	DefaultUsernamePattern    = "{{.Prefix}}_{{.RunID}}_{{.ID}}"
+	DefaultEmailDomainPattern = "sendgrid.com"
+)
+
+// Seed makes every generated value of a run reproducible
+var Seed int64
+var RunID string
+var UsernamePattern, EmailDomainPattern, PasswordPattern string
+
+var usernameTemplate, emailDomainTemplate, passwordTemplate *template.Template
+
+// NameData is what the username, email domain and password patterns can use
+type NameData struct {
+	// Prefix is testuser for parents and testsubuser for subusers
+	Prefix string
+	// Template is the name of the scenario template the account comes from
+	Template string
+	RunID    string
+	// Index is the position of the account in the run, subusers have the index of their reseller
+	Index int
+	// Subuser is the position of a subuser under its reseller starting at 1, it is 0 for parents
+	Subuser int
+	// ID is Index for parents and Index-Subuser for subusers, it is unique within a run
+	ID string
+	// UUID and Random are random but reproducible with the same seed
+	UUID   string
+	Random string
+}
+
+// setupNaming picks the seed and parses the patterns.
+// A seed of 0 picks one from the clock, it is printed so the run can be repeated
+func setupNaming() error {
+	if Seed == 0 {
+		Seed = time.Now().UnixNano()
+	}
+	RunID = fmt.Sprintf("%08x", rand.New(rand.NewSource(Seed)).Uint32())
+	fmt.Printf("run %s, use -seed %d to repeat it\n", RunID, Seed)
+
+	var err error
+	usernameTemplate, err = parsePattern("username", UsernamePattern)
+	if err != nil {
+		return err
+	}
+	emailDomainTemplate, err = parsePattern("email-domain", EmailDomainPattern)
+	if err != nil {
+		return err
+	}
+	passwordTemplate, err = parsePattern("password", PasswordPattern)
+	if err != nil {
+		return err
+	}
+
+	// catch patterns that only fail when they are executed before any user is created
+	_, err = newAccount(NameData{Prefix: "testuser", Template: "check", RunID: RunID})
+	return err
+}
+
+func parsePattern(name string, pattern string) (*template.Template, error) {
+	t, err := template.New(name).Parse(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s pattern: %s", name, err.Error())
+	}
+	return t, nil
+}
+
+// accountRand is the random source for one account, it only depends on the seed and the account's id
+// so the values do not change with the order the workers get to the accounts
+func accountRand(id string) *rand.Rand {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	return rand.New(rand.NewSource(Seed ^ int64(h.Sum64())))
+}
+
+// newAccount generates the credentials for a new account from the patterns
+func newAccount(data NameData) (*Account, error) {
+	if data.ID == "" {
+		data.ID = fmt.Sprintf("%d", data.Index)
+		if data.Subuser > 0 {
+			data.ID = fmt.Sprintf("%d-%d", data.Index, data.Subuser)
+		}
+	}
+
+	r := accountRand(data.Prefix + data.ID)
+	b := make([]byte, 16)
+	for i := range b {
+		b[i] = byte(r.Intn(256))
+	}
+	// make it a version 4 uuid like uuid.New
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	data.UUID = uuid.UUID(b).String()
+	data.Random = fmt.Sprintf("%08x", r.Uint32())
+
+	username, err := executePattern(usernameTemplate, data)
+	if err != nil {
+		return nil, err
+	}
+	domain, err := executePattern(emailDomainTemplate, data)
+	if err != nil {
+		return nil, err
+	}
+	password, err := executePattern(passwordTemplate, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Account{
+		Username: username,
+		Email:    username + "@" + domain,
+		Password: password,
+		IPs:      []string{},
+	}, nil
+}
+
+func executePattern(t *template.Template, data NameData) (string, error) {
+	var b bytes.Buffer
+	err := t.Execute(&b, data)
+	if err != nil {
+		return "", fmt.Errorf("unable to execute %s pattern: %s", t.Name(), err.Error())
+	}
+
+	value := strings.TrimSpace(b.String())
+	if value == "" {
+		return "", errors.New(t.Name() + " pattern is empty for " + data.ID)
+	}
+	return value, nil
+}
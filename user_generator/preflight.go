@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sendgrid/chaos/adaptor/authzd"
+	"github.com/sendgrid/chaos/adaptor/boss"
+	"github.com/sendgrid/chaos/adaptor/crudalerts"
+	"github.com/sendgrid/chaos/adaptor/gandalf"
+	"github.com/sendgrid/go-komodo"
+)
+
+const (
+	checkTimeout = 10 * time.Second
+)
+
+var SkipChecks bool
+
+// requiredApidFunctions are the apid functions the generator and chaos signup call
+var requiredApidFunctions = []string{
+	"add",
+	"addBounceManagementSettings",
+	"addExternalIp",
+	"addUser",
+	"addUserFilters",
+	"addUserIpGroup",
+	"addUserSendIp",
+	"assignBestAvailableOp",
+	"countSubusers",
+	"editExternalIp",
+	"editUser",
+	"editUserProfile",
+	"enableUserFilter",
+	"executeSql",
+	"get",
+	"getAssignmentPolicy",
+	"getBestAvailableIp",
+	"getExternalIp",
+	"getPackage",
+	"getUserCreditLimit",
+	"getUserHolds",
+	"getUseridsByReseller",
+	"getUserPackageType",
+	"getUserSendIp",
+	"removeUserIpGroup",
+	"setUserActive",
+	"setUserCreditLimit",
+	"setUserIpGroup",
+	"softDeleteUser",
+	"update",
+	"validateExternalIps",
+}
+
+// CheckResult is the outcome of checking one dependency
+type CheckResult struct {
+	Name    string
+	Skipped bool
+	Err     error
+}
+
+// runCheck only runs the pre-flight checks
+func runCheck(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	registerConfigFlags(fs)
+	err := parseFlags(fs, args)
+	if err != nil {
+		fmt.Printf("oh no there's an error loading the config! %s\n", err.Error())
+		return 2
+	}
+
+	setupClients()
+
+	if !preflight(os.Stdout) {
+		return 1
+	}
+	return 0
+}
+
+// preflight checks chaos, apid and every configured dependency, writes a go/no-go report
+// and reports if it is safe to go
+func preflight(out io.Writer) bool {
+	results := []CheckResult{
+		check(&komodo.BasicHealthcheck{HealthcheckName: "chaos", Healthcheck: checkChaos}),
+		check(ApidClient),
+		check(&komodo.BasicHealthcheck{HealthcheckName: "apid functions", Healthcheck: checkApidFunctions}),
+	}
+
+	if Conf.Gandalf != "" {
+		results = append(results, checkAdaptor("gandalf", Conf.Gandalf, func(host string, port int) komodo.Healthcheck {
+			return gandalf.New(host, port, Conf.GandalfHealthcheckPort)
+		}))
+	} else {
+		results = append(results, CheckResult{Name: "gandalf", Skipped: true})
+	}
+
+	if Conf.Authzd != "" {
+		results = append(results, checkAdaptor("authzd", Conf.Authzd, func(host string, port int) komodo.Healthcheck {
+			return authzd.New(host, port, Conf.AuthzdHealthcheckPort)
+		}))
+	} else {
+		results = append(results, CheckResult{Name: "authzd", Skipped: true})
+	}
+
+	if Conf.CrudAlerts != "" {
+		results = append(results, checkAdaptor("crud_alerts", Conf.CrudAlerts, func(host string, port int) komodo.Healthcheck {
+			return crudalerts.NewCrudClient(host, port, Conf.CrudAlertsHealthcheckPort)
+		}))
+	} else {
+		results = append(results, CheckResult{Name: "crud_alerts", Skipped: true})
+	}
+
+	if Conf.Boss != "" {
+		// the boss adaptor fetches its urls when it is created, so that happens inside the timeout too
+		results = append(results, check(&komodo.BasicHealthcheck{HealthcheckName: "boss", Healthcheck: func() error {
+			return boss.New(Conf.Boss, Conf.BossToken, nil, time.Minute).Check()
+		}}))
+	} else {
+		results = append(results, CheckResult{Name: "boss", Skipped: true})
+	}
+
+	return writeCheckReport(out, results)
+}
+
+// check runs the healthcheck with a timeout.
+// Some adaptors panic when a service answers with an error status, so that is reported as a failure
+func check(h komodo.Healthcheck) CheckResult {
+	result := CheckResult{Name: h.Name()}
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("healthcheck panicked: %v", r)
+			}
+		}()
+		done <- h.Check()
+	}()
+
+	select {
+	case result.Err = <-done:
+	case <-time.After(checkTimeout):
+		result.Err = fmt.Errorf("timed out after %s", checkTimeout)
+	}
+
+	return result
+}
+
+// checkAdaptor checks an adaptor that takes a host and port rather than a url
+func checkAdaptor(name string, baseURL string, newAdaptor func(host string, port int) komodo.Healthcheck) CheckResult {
+	host, port, err := hostPort(baseURL)
+	if err != nil {
+		return CheckResult{Name: name, Err: err}
+	}
+	return check(newAdaptor(host, port))
+}
+
+func checkChaos() error {
+	req, err := http.NewRequest("GET", Conf.Chaos+"/healthcheck", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status code - got %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	return nil
+}
+
+// checkApidFunctions makes sure every function the generator needs is in the function list go-apid caches
+func checkApidFunctions() error {
+	req, err := http.NewRequest("GET", ApidClient.BaseURL+"/api/functions.json", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status code - got %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	var functions struct {
+		Functions map[string]json.RawMessage `json:"functions"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&functions)
+	if err != nil {
+		return err
+	}
+
+	missing := make([]string, 0)
+	for _, name := range requiredApidFunctions {
+		if _, ok := functions.Functions[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+
+	if len(missing) > 0 {
+		return errors.New("missing " + strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// writeCheckReport writes the result of every check and reports if none of them failed
+func writeCheckReport(out io.Writer, results []CheckResult) bool {
+	ok := true
+
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "DEPENDENCY\tSTATUS\tDETAIL")
+	for _, r := range results {
+		switch {
+		case r.Skipped:
+			fmt.Fprintf(w, "%s\tskipped\tnot configured\n", r.Name)
+		case r.Err != nil:
+			ok = false
+			fmt.Fprintf(w, "%s\tfailed\t%s\n", r.Name, r.Err.Error())
+		default:
+			fmt.Fprintf(w, "%s\tok\t\n", r.Name)
+		}
+	}
+	w.Flush()
+
+	if ok {
+		fmt.Fprintln(out, "go")
+	} else {
+		fmt.Fprintln(out, "no-go")
+	}
+
+	return ok
+}
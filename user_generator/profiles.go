@@ -0,0 +1,366 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"github.com/sendgrid/chaos/client"
+)
+
+// ProfileOptions controls the profile generated for each account of a template
+type ProfileOptions struct {
+	// Locales to pick from for each account, every locale when empty
+	Locales []string `json:"locales"`
+	// Fields to fill, named like the json of client.UserProfile. Every field but multifactor_phone
+	// when it is left out, and no field at all when it is an empty list
+	Fields []string `json:"fields"`
+	// ProvisionFail marks the profile as failing provisioning
+	ProvisionFail bool `json:"provision_fail"`
+}
+
+// profileFields are the client.UserProfile fields that can be generated
+var profileFields = []string{
+	"first_name",
+	"last_name",
+	"company",
+	"address",
+	"address2",
+	"city",
+	"state",
+	"zip",
+	"country",
+	"phone",
+	"website",
+	"multifactor_phone",
+}
+
+// multifactor_phone turns on two factor flows so it is only filled when asked for
+var defaultProfileFields = profileFields[:len(profileFields)-1]
+
+type city struct {
+	Name  string
+	State string
+	Zip   string
+}
+
+// locale is the data profiles are generated from, # in a pattern is a digit and ? an upper case letter
+type locale struct {
+	Country       string
+	FirstNames    []string
+	LastNames     []string
+	Cities        []city
+	Streets       []string
+	NumberFirst   bool
+	Address2      []string
+	CompanyWords  []string
+	CompanySuffix []string
+	Phone         string
+	MobilePhone   string
+	WebsiteDomain string
+	// Language, UTCOffset in minutes and DST describe the browser of someone signing up from the locale
+	Language  string
+	UTCOffset int
+	DST       bool
+}
+
+// locales is compiled in so profiles never need the network
+var locales = map[string]locale{
+	"en_US": {
+		Country:    "US",
+		FirstNames: []string{"James", "Mary", "Robert", "Patricia", "Michael", "Jennifer", "David", "Linda", "Carlos", "Aisha", "Kevin", "Emily"},
+		LastNames:  []string{"Smith", "Johnson", "Williams", "Brown", "Garcia", "Miller", "Davis", "Rodriguez", "Nguyen", "Washington", "O'Brien", "Kim"},
+		Cities: []city{
+			{"Denver", "CO", "802##"},
+			{"Boulder", "CO", "803##"},
+			{"Redwood City", "CA", "940##"},
+			{"Irvine", "CA", "926##"},
+			{"Austin", "TX", "787##"},
+			{"Chicago", "IL", "606##"},
+			{"Brooklyn", "NY", "112##"},
+			{"Seattle", "WA", "981##"},
+		},
+		Streets:       []string{"Main St", "Oak Ave", "Pearl St", "Walnut St", "Maple Dr", "Broadway", "Lincoln Blvd", "Cedar Ln"},
+		NumberFirst:   true,
+		Address2:      []string{"Suite ###", "Apt ##", "Floor #", "Unit ?"},
+		CompanyWords:  []string{"Acme", "Summit", "Blue Ridge", "Pioneer", "Evergreen", "Granite", "Lakeside", "Northstar"},
+		CompanySuffix: []string{"Inc.", "LLC", "Co.", "Corp."},
+		Phone:         "+1 (###) ###-####",
+		MobilePhone:   "+1 (###) ###-####",
+		WebsiteDomain: "com",
+		Language:      "en-US",
+		UTCOffset:     -420,
+		DST:           true,
+	},
+	"en_GB": {
+		Country:    "GB",
+		FirstNames: []string{"Oliver", "Amelia", "George", "Isla", "Harry", "Ava", "Jack", "Emily", "Priya", "Mohammed", "Siobhan", "Rhys"},
+		LastNames:  []string{"Smith", "Jones", "Taylor", "Brown", "Williams", "Wilson", "Evans", "Thomas", "Patel", "Khan", "Murphy", "Hughes"},
+		Cities: []city{
+			{"London", "Greater London", "SW# #??"},
+			{"Manchester", "Greater Manchester", "M# #??"},
+			{"Edinburgh", "Scotland", "EH# #??"},
+			{"Cardiff", "Wales", "CF## #??"},
+			{"Bristol", "Bristol", "BS# #??"},
+			{"Leeds", "West Yorkshire", "LS# #??"},
+		},
+		Streets:       []string{"High Street", "Station Road", "Church Lane", "Victoria Road", "Park Avenue", "Queen's Road"},
+		NumberFirst:   true,
+		Address2:      []string{"Flat #", "Unit ##", "Floor #"},
+		CompanyWords:  []string{"Thames", "Crown", "Albion", "Kingsway", "Heath", "Pennine"},
+		CompanySuffix: []string{"Ltd", "PLC", "LLP"},
+		Phone:         "+44 20 #### ####",
+		MobilePhone:   "+44 7### ######",
+		WebsiteDomain: "co.uk",
+		Language:      "en-GB",
+		UTCOffset:     0,
+		DST:           true,
+	},
+	"de_DE": {
+		Country:    "DE",
+		FirstNames: []string{"Lukas", "Anna", "Jonas", "Lea", "Felix", "Sophie", "Maximilian", "Marie", "Jürgen", "Özlem", "Katrin", "Tobias"},
+		LastNames:  []string{"Müller", "Schmidt", "Schneider", "Fischer", "Weber", "Meyer", "Wagner", "Becker", "Schäfer", "Yılmaz", "Hoffmann", "Koch"},
+		Cities: []city{
+			{"Berlin", "Berlin", "10###"},
+			{"München", "Bayern", "80###"},
+			{"Hamburg", "Hamburg", "20###"},
+			{"Köln", "Nordrhein-Westfalen", "50###"},
+			{"Frankfurt am Main", "Hessen", "60###"},
+			{"Stuttgart", "Baden-Württemberg", "70###"},
+		},
+		Streets:       []string{"Hauptstraße", "Schulstraße", "Gartenstraße", "Bahnhofstraße", "Lindenweg", "Am Markt"},
+		NumberFirst:   false,
+		Address2:      []string{"#. OG", "Hinterhaus", "Zimmer ##"},
+		CompanyWords:  []string{"Nordlicht", "Alpen", "Rhein", "Hansa", "Schwarzwald", "Elbe"},
+		CompanySuffix: []string{"GmbH", "AG", "KG", "GmbH & Co. KG"},
+		Phone:         "+49 30 ########",
+		MobilePhone:   "+49 15# ########",
+		WebsiteDomain: "de",
+		Language:      "de-DE",
+		UTCOffset:     60,
+		DST:           true,
+	},
+	"fr_FR": {
+		Country:    "FR",
+		FirstNames: []string{"Gabriel", "Louise", "Raphaël", "Emma", "Léo", "Chloé", "Hugo", "Inès", "Théo", "Zoé", "Mathis", "Océane"},
+		LastNames:  []string{"Martin", "Bernard", "Dubois", "Thomas", "Robert", "Richard", "Petit", "Durand", "Lefèvre", "Benali", "Moreau", "Girard"},
+		Cities: []city{
+			{"Paris", "Île-de-France", "750##"},
+			{"Lyon", "Auvergne-Rhône-Alpes", "6900#"},
+			{"Marseille", "Provence-Alpes-Côte d'Azur", "130##"},
+			{"Toulouse", "Occitanie", "310##"},
+			{"Nantes", "Pays de la Loire", "440##"},
+			{"Lille", "Hauts-de-France", "590##"},
+		},
+		Streets:       []string{"rue de la Paix", "avenue Victor Hugo", "boulevard Saint-Michel", "rue du Moulin", "place de la République", "rue des Écoles"},
+		NumberFirst:   true,
+		Address2:      []string{"Bâtiment ?", "Escalier #", "#e étage"},
+		CompanyWords:  []string{"Lumière", "Bastille", "Azur", "Loire", "Mistral", "Garonne"},
+		CompanySuffix: []string{"SARL", "SA", "SAS"},
+		Phone:         "+33 1 ## ## ## ##",
+		MobilePhone:   "+33 6 ## ## ## ##",
+		WebsiteDomain: "fr",
+		Language:      "fr-FR",
+		UTCOffset:     60,
+		DST:           true,
+	},
+	"pt_BR": {
+		Country:    "BR",
+		FirstNames: []string{"Miguel", "Alice", "Arthur", "Sophia", "Heitor", "Helena", "Davi", "Valentina", "João", "Júlia", "Enzo", "Lívia"},
+		LastNames:  []string{"Silva", "Santos", "Oliveira", "Souza", "Rodrigues", "Ferreira", "Alves", "Pereira", "Lima", "Gomes", "Araújo", "Conceição"},
+		Cities: []city{
+			{"São Paulo", "SP", "01###-###"},
+			{"Rio de Janeiro", "RJ", "20###-###"},
+			{"Belo Horizonte", "MG", "30###-###"},
+			{"Porto Alegre", "RS", "90###-###"},
+			{"Recife", "PE", "50###-###"},
+			{"Curitiba", "PR", "80###-###"},
+		},
+		Streets:       []string{"Rua das Flores", "Avenida Paulista", "Rua Augusta", "Avenida Brasil", "Rua XV de Novembro", "Rua da Consolação"},
+		NumberFirst:   false,
+		Address2:      []string{"Apto ###", "Sala ##", "Bloco ?"},
+		CompanyWords:  []string{"Horizonte", "Ipê", "Atlântico", "Cerrado", "Tropical", "Amazonas"},
+		CompanySuffix: []string{"Ltda.", "S.A.", "ME"},
+		Phone:         "+55 11 ####-####",
+		MobilePhone:   "+55 11 9####-####",
+		WebsiteDomain: "com.br",
+		Language:      "pt-BR",
+		UTCOffset:     -180,
+		DST:           false,
+	},
+	"ja_JP": {
+		Country:    "JP",
+		FirstNames: []string{"Haruto", "Yui", "Sota", "Hina", "Yuto", "Aoi", "Ren", "Sakura", "Kenji", "Yuki", "Daiki", "Mei"},
+		LastNames:  []string{"Sato", "Suzuki", "Takahashi", "Tanaka", "Watanabe", "Ito", "Yamamoto", "Nakamura", "Kobayashi", "Kato", "Yoshida", "Yamada"},
+		Cities: []city{
+			{"Shibuya-ku", "Tokyo", "150-####"},
+			{"Minato-ku", "Tokyo", "105-####"},
+			{"Osaka", "Osaka", "530-####"},
+			{"Yokohama", "Kanagawa", "220-####"},
+			{"Kyoto", "Kyoto", "600-####"},
+			{"Fukuoka", "Fukuoka", "810-####"},
+		},
+		Streets:       []string{"Jingumae 1-chome", "Shiba 3-chome", "Umeda 2-chome", "Minatomirai 4-chome", "Tenjin 2-chome"},
+		NumberFirst:   false,
+		Address2:      []string{"Room ###", "#F", "Building ##"},
+		CompanyWords:  []string{"Sakura", "Fuji", "Hikari", "Kaze", "Midori", "Yamato"},
+		CompanySuffix: []string{"K.K.", "Co., Ltd.", "G.K."},
+		Phone:         "+81 3-####-####",
+		MobilePhone:   "+81 90-####-####",
+		WebsiteDomain: "co.jp",
+		Language:      "ja-JP",
+		UTCOffset:     540,
+		DST:           false,
+	},
+}
+
+// localeNames returns the names of the compiled in locales in order
+func localeNames() []string {
+	names := make([]string, 0, len(locales))
+	for name := range locales {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// pickLocale picks one of names, or one of every locale when there are none
+func pickLocale(names []string, r *rand.Rand) string {
+	if len(names) == 0 {
+		names = localeNames()
+	}
+	return names[r.Intn(len(names))]
+}
+
+// localeForCountry finds the locale for a profile's country
+func localeForCountry(country string) (locale, bool) {
+	for _, name := range localeNames() {
+		if locales[name].Country == country {
+			return locales[name], true
+		}
+	}
+	return locale{}, false
+}
+
+// generateProfile makes a believable profile for one account from its random source
+func generateProfile(options *ProfileOptions, r *rand.Rand) *client.UserProfile {
+	if options == nil {
+		options = &ProfileOptions{}
+	}
+
+	l := locales[pickLocale(options.Locales, r)]
+
+	firstName := pick(r, l.FirstNames)
+	lastName := pick(r, l.LastNames)
+	c := l.Cities[r.Intn(len(l.Cities))]
+	companyWord := pick(r, l.CompanyWords)
+	company := companyWord + " " + pick(r, l.CompanySuffix)
+
+	number := fill(r, "###")
+	number = strings.TrimLeft(number, "0")
+	if number == "" {
+		number = "1"
+	}
+	street := fill(r, pick(r, l.Streets))
+	address := number + " " + street
+	if !l.NumberFirst {
+		address = street + " " + number
+	}
+
+	values := map[string]string{
+		"first_name":        firstName,
+		"last_name":         lastName,
+		"company":           company,
+		"address":           address,
+		"address2":          fill(r, pick(r, l.Address2)),
+		"city":              c.Name,
+		"state":             c.State,
+		"zip":               fill(r, c.Zip),
+		"country":           l.Country,
+		"phone":             fill(r, l.Phone),
+		"website":           fmt.Sprintf("https://www.%s.%s", slug(companyWord), l.WebsiteDomain),
+		"multifactor_phone": fill(r, l.MobilePhone),
+	}
+
+	fields := options.Fields
+	if fields == nil {
+		fields = defaultProfileFields
+	}
+
+	profile := &client.UserProfile{}
+	for _, field := range fields {
+		setProfileField(profile, field, values[field])
+	}
+	if options.ProvisionFail {
+		profile.IsProvisionFail = 1
+	}
+
+	return profile
+}
+
+func setProfileField(profile *client.UserProfile, field string, value string) {
+	switch field {
+	case "first_name":
+		profile.FirstName = value
+	case "last_name":
+		profile.LastName = value
+	case "company":
+		profile.Company = value
+	case "address":
+		profile.Address1 = value
+	case "address2":
+		profile.Address2 = value
+	case "city":
+		profile.City = value
+	case "state":
+		profile.State = value
+	case "zip":
+		profile.Zip = value
+	case "country":
+		profile.Country = value
+	case "phone":
+		profile.Phone = value
+	case "website":
+		profile.Website = value
+	case "multifactor_phone":
+		profile.MultifactorPhone = value
+	}
+}
+
+func pick(r *rand.Rand, values []string) string {
+	return values[r.Intn(len(values))]
+}
+
+// fill replaces each # in the pattern with a digit and each ? with an upper case letter
+func fill(r *rand.Rand, pattern string) string {
+	out := make([]rune, 0, len(pattern))
+	for _, c := range pattern {
+		switch c {
+		case '#':
+			out = append(out, rune('0'+r.Intn(10)))
+		case '?':
+			out = append(out, rune('A'+r.Intn(26)))
+		default:
+			out = append(out, c)
+		}
+	}
+	return string(out)
+}
+
+// unaccent covers the accented letters in the company words
+var unaccent = strings.NewReplacer("à", "a", "â", "a", "ã", "a", "ä", "ae", "ç", "c", "é", "e", "è", "e", "ê", "e", "í", "i", "ô", "o", "ö", "oe", "ú", "u", "ü", "ue")
+
+// slug makes a company name usable in a host name
+func slug(name string) string {
+	var b []rune
+	for _, c := range unaccent.Replace(strings.ToLower(name)) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+			b = append(b, c)
+		case c == ' ' || c == '-':
+			b = append(b, '-')
+		}
+	}
+	return strings.Trim(string(b), "-")
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"strings"
+
+	apidadaptor "github.com/sendgrid/chaos/adaptor/apid"
+	"github.com/sendgrid/chaos/client"
+)
+
+// TalonVersion is the version of the fingerprint the signup page sends
+const TalonVersion = 1
+
+// ProvisionOptions controls the provisioning answers given for each account of a template.
+// Each account gets one value picked from every list, the defaults are used for empty lists
+type ProvisionOptions struct {
+	Skip         bool     `json:"skip"`
+	Industries   []string `json:"industries"`
+	EmailVolumes []string `json:"email_volumes"`
+	Personas     []string `json:"personas"`
+	// NoFingerprint skips adding the talon fingerprint
+	NoFingerprint bool `json:"no_fingerprint"`
+}
+
+// the answers the provisioning form offers
+var (
+	defaultIndustries = []string{
+		"Retail/eCommerce",
+		"Technology/Software",
+		"Financial Services",
+		"Healthcare",
+		"Education",
+		"Media/Entertainment",
+		"Travel/Hospitality",
+		"Non-profit",
+		"Real Estate",
+		"Marketing/Advertising Agency",
+		"Other",
+	}
+	defaultEmailVolumes = []string{
+		"0 - 40,000",
+		"40,001 - 100,000",
+		"100,001 - 700,000",
+		"700,001 - 1,500,000",
+		"1,500,001+",
+	}
+	defaultPersonas = []string{
+		"developer",
+		"marketer",
+		"business owner",
+		"executive",
+		"other",
+	}
+)
+
+var userAgents = []string{
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_10_5) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/45.0.2454.101 Safari/537.36",
+	"Mozilla/5.0 (Windows NT 6.1; WOW64; rv:41.0) Gecko/20100101 Firefox/41.0",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/46.0.2490.71 Safari/537.36 Edge/12.10240",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_11) AppleWebKit/601.1.56 (KHTML, like Gecko) Version/9.0 Safari/601.1.56",
+	"Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:41.0) Gecko/20100101 Firefox/41.0",
+	"Mozilla/5.0 (iPhone; CPU iPhone OS 9_0_2 like Mac OS X) AppleWebKit/601.1.46 (KHTML, like Gecko) Version/9.0 Mobile/13A452 Safari/601.1",
+}
+
+// skip reports if the template turns provisioning off, it is on by default
+func (o *ProvisionOptions) skip() bool {
+	return o != nil && o.Skip
+}
+
+func (o *ProvisionOptions) validate() error {
+	// UpdateUserBI puts the answers straight into json
+	for _, values := range [][]string{o.Industries, o.EmailVolumes, o.Personas} {
+		for _, v := range values {
+			if strings.ContainsAny(v, `"\`) {
+				return fmt.Errorf("provision answer %q can not contain quotes or backslashes", v)
+			}
+		}
+	}
+	return nil
+}
+
+// provisionUser answers the provisioning questions for the user and adds the fingerprint of the browser they signed up with
+func provisionUser(userID int, options *ProvisionOptions, profile *client.UserProfile, r *rand.Rand) error {
+	if options == nil {
+		options = &ProvisionOptions{}
+	}
+
+	fingerprint := generateFingerprint(userID, profile.Country, r)
+	talon, err := json.Marshal(fingerprint)
+	if err != nil {
+		return err
+	}
+
+	p := client.Provision{
+		UserProfile: *profile,
+		Talon:       string(talon),
+		Industry:    pick(r, orDefault(options.Industries, defaultIndustries)),
+		EmailVolume: pick(r, orDefault(options.EmailVolumes, defaultEmailVolumes)),
+		UserPersona: pick(r, orDefault(options.Personas, defaultPersonas)),
+	}
+	p.UserProfile.UserID = userID
+
+	err = storeSignupBI(p)
+	if err != nil {
+		return err
+	}
+
+	if options.NoFingerprint {
+		return nil
+	}
+
+	_, adaptorErr := ApidAdaptor.AddUserFingerprint(fingerprint)
+	if adaptorErr != nil {
+		return fmt.Errorf("unable to add fingerprint: %s", adaptorErr.Error())
+	}
+
+	return nil
+}
+
+// signupBI is the user's row in user_signup_bi
+type signupBI struct {
+	UserID      int    `json:"user_id"`
+	Industry    string `json:"industry"`
+	EmailVolume string `json:"volume"`
+	UserPersona string `json:"user_persona"`
+}
+
+// getSignupBI reads the user's signup bi rows, there are none when the signup sent no bi cookie
+func getSignupBI(userID int) ([]signupBI, error) {
+	var rows []signupBI
+	err := ApidClient.DoFunction("get", url.Values{
+		"tableName": []string{"user_signup_bi"},
+		"where":     []string{fmt.Sprintf(`{"user_id" : "%d"}`, userID)},
+	}, &rows)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read signup bi: %s", err.Error())
+	}
+	return rows, nil
+}
+
+// storeSignupBI puts the answers in the user's signup bi row and reads them back.
+// UpdateUserBI only changes a row signup added, so the row is added when signup did not
+func storeSignupBI(p client.Provision) error {
+	userID := p.UserProfile.UserID
+	rows, err := getSignupBI(userID)
+	if err != nil {
+		return err
+	}
+
+	if len(rows) == 0 {
+		values, err := json.Marshal([]signupBI{{
+			UserID:      userID,
+			Industry:    p.Industry,
+			EmailVolume: p.EmailVolume,
+			UserPersona: p.UserPersona,
+		}})
+		if err != nil {
+			return err
+		}
+
+		var response string
+		err = ApidClient.DoFunction("add", url.Values{
+			"tableName": []string{"user_signup_bi"},
+			"values":    []string{string(values)},
+		}, &response)
+		if err != nil {
+			return fmt.Errorf("unable to add signup bi: %s", err.Error())
+		}
+		if response != apidadaptor.ApidSuccess {
+			return fmt.Errorf("unable to add signup bi: apid returned %q", response)
+		}
+	} else {
+		adaptorErr := ApidAdaptor.UpdateUserBI(p)
+		if adaptorErr != nil {
+			return fmt.Errorf("unable to update signup bi: %s", adaptorErr.Error())
+		}
+	}
+
+	rows, err = getSignupBI(userID)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return fmt.Errorf("user %d has no signup bi after provisioning", userID)
+	}
+	for _, row := range rows {
+		if row.Industry != p.Industry || row.EmailVolume != p.EmailVolume || row.UserPersona != p.UserPersona {
+			return fmt.Errorf("signup bi has industry %q, volume %q and persona %q, want %q, %q and %q",
+				row.Industry, row.EmailVolume, row.UserPersona, p.Industry, p.EmailVolume, p.UserPersona)
+		}
+	}
+
+	return nil
+}
+
+// generateFingerprint makes a talon fingerprint for a browser in the country, any locale's when the country is unknown
+func generateFingerprint(userID int, country string, r *rand.Rand) apidadaptor.TalonFingerprint {
+	l, ok := localeForCountry(country)
+	if !ok {
+		l = locales[pickLocale(nil, r)]
+	}
+
+	return apidadaptor.TalonFingerprint{
+		UserID:  userID,
+		Version: TalonVersion,
+		Timezone: apidadaptor.TalonFingerprintTimezone{
+			DST:  l.DST,
+			TZO:  l.UTCOffset,
+			STZO: l.UTCOffset,
+		},
+		Language:    l.Language,
+		UserAgent:   pick(r, userAgents),
+		Fingerprint: fmt.Sprintf("%08x%08x%08x%08x", r.Uint32(), r.Uint32(), r.Uint32(), r.Uint32()),
+	}
+}
+
+func orDefault(values []string, defaults []string) []string {
+	if len(values) == 0 {
+		return defaults
+	}
+	return values
+}
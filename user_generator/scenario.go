@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	apidadaptor "github.com/sendgrid/chaos/adaptor/apid"
+	"github.com/sendgrid/chaos/client"
+)
+
+const (
+	DefaultPackageID = 11
+)
+
+// Scenario describes the whole fleet of accounts a run should generate
+type Scenario struct {
+	// FilterSets are named lists of filters templates can share, "signup" is built in
+	FilterSets map[string][]FilterTemplate `json:"filter_sets"`
+	Accounts   []AccountTemplate           `json:"accounts"`
+}
+
+// AccountTemplate describes Count identical accounts
+type AccountTemplate struct {
+	Name        string              `json:"name"`
+	Count       int                 `json:"count"`
+	PackageID   int                 `json:"package_id"`
+	PackageUUID string              `json:"package_uuid"`
+	IPs         int                 `json:"ips"`
+	Subusers    int                 `json:"subusers"`
+	CreditLimit *CreditLimit        `json:"credit_limit"`
+	Profile     *client.UserProfile `json:"profile"`
+	FilterSets  []string            `json:"filter_sets"`
+	Filters     []FilterTemplate    `json:"filters"`
+	Active      *bool               `json:"active"`
+	// GeneratedProfile controls the profile made up for each account when there is no fixed profile
+	GeneratedProfile *ProfileOptions `json:"generated_profile"`
+	// Provision controls the answers to the provisioning questions, accounts are provisioned unless it says to skip
+	Provision *ProvisionOptions `json:"provision"`
+	// BounceManagement turns on bounce management, UsageAlerts registers a usage notification
+	// at UsageAlertThreshold percent that goes to the account's email
+	BounceManagement bool `json:"bounce_management"`
+	UsageAlerts      bool `json:"usage_alerts"`
+	// Scopes controls the authzd scope set the accounts get when -authzd is set
+	Scopes *ScopeOptions `json:"scopes"`
+	// Lifecycle leaves the accounts deactivated, downgraded, soft deleted, on hold or reseller disabled
+	Lifecycle *LifecycleOptions `json:"lifecycle"`
+	// IPAssignment controls how the IPs are picked and the ip group the accounts send from
+	IPAssignment *IPOptions `json:"ip_assignment"`
+	// Cookies attaches bi and partner cookies, well formed or not, to the signup
+	Cookies *CookieOptions `json:"cookies"`
+	// ConfirmEmail activates the accounts through chaos's email confirmation instead of apid, it needs -mail-sink
+	ConfirmEmail bool `json:"confirm_email"`
+	// Billing subscribes the accounts to a paid package through boss after they are put on PackageID
+	Billing *BillingOptions `json:"billing"`
+}
+
+// CreditLimit is the number of credits a user can spend per period (monthly or daily).
+// Without one, users on a lite package get the package's starting credits per day
+type CreditLimit struct {
+	Credits int    `json:"credits"`
+	Period  string `json:"period"`
+}
+
+// IsActive reports if the accounts should be left active, which is the default
+func (t *AccountTemplate) IsActive() bool {
+	return t.Active == nil || *t.Active
+}
+
+// defaultScenario builds the scenario described by the -users and -subusers flags
+func defaultScenario() *Scenario {
+	return &Scenario{
+		Accounts: []AccountTemplate{
+			{
+				Name:      "default",
+				Count:     TotalUsers,
+				PackageID: DefaultPackageID,
+				IPs:       1,
+				Subusers:  SubusersPerUser,
+			},
+		},
+	}
+}
+
+// loadScenario reads a json scenario file and resolves any package uuids to package ids
+func loadScenario(path string) (*Scenario, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var scenario Scenario
+	err = json.NewDecoder(f).Decode(&scenario)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse scenario %s: %s", path, err.Error())
+	}
+
+	if len(scenario.Accounts) == 0 {
+		return nil, fmt.Errorf("scenario %s has no accounts", path)
+	}
+
+	for i := range scenario.Accounts {
+		t := &scenario.Accounts[i]
+		if t.Name == "" {
+			t.Name = fmt.Sprintf("account_%d", i)
+		}
+
+		t.Filters, err = resolveFilters(scenario.FilterSets, t.FilterSets, t.Filters)
+		if err != nil {
+			return nil, fmt.Errorf("invalid account template %q: %s", t.Name, err.Error())
+		}
+
+		err = t.validate()
+		if err != nil {
+			return nil, fmt.Errorf("invalid account template %q: %s", t.Name, err.Error())
+		}
+
+		if t.PackageUUID != "" {
+			packageID, adaptorErr := ApidAdaptor.PackageIDFromUUID(t.PackageUUID)
+			if adaptorErr != nil {
+				return nil, fmt.Errorf("unable to find package %s for account template %q: %s", t.PackageUUID, t.Name, adaptorErr.Error())
+			}
+			t.PackageID = packageID
+		}
+
+		if t.Billing != nil {
+			err = t.Billing.lookupCoupon()
+			if err != nil {
+				return nil, fmt.Errorf("invalid account template %q: %s", t.Name, err.Error())
+			}
+		}
+
+		if t.IPAssignment != nil {
+			err = t.IPAssignment.lookupIPs()
+			if err != nil {
+				return nil, fmt.Errorf("invalid account template %q: %s", t.Name, err.Error())
+			}
+		}
+	}
+
+	return &scenario, nil
+}
+
+func (t *AccountTemplate) validate() error {
+	if t.Count < 1 {
+		return errors.New("count must be at least 1")
+	}
+	if t.PackageID != 0 && t.PackageUUID != "" {
+		return errors.New("only one of package_id and package_uuid can be set")
+	}
+	if t.PackageID == 0 && t.PackageUUID == "" {
+		t.PackageID = DefaultPackageID
+	}
+	if t.IPs < 0 || t.Subusers < 0 {
+		return errors.New("ips and subusers can not be negative")
+	}
+	if t.CreditLimit != nil {
+		if t.CreditLimit.Credits < 0 {
+			return errors.New("credit limit credits can not be negative")
+		}
+		if t.CreditLimit.Period == "" {
+			t.CreditLimit.Period = apidadaptor.FreeAccountCreditPeriod
+		}
+		if t.CreditLimit.Period != apidadaptor.FreeAccountCreditPeriod && t.CreditLimit.Period != apidadaptor.LitePlanCreditPeriod {
+			return fmt.Errorf("credit limit period must be %s or %s", apidadaptor.FreeAccountCreditPeriod, apidadaptor.LitePlanCreditPeriod)
+		}
+	}
+	for _, f := range t.Filters {
+		if _, ok := filterIDs[f.Name]; !ok {
+			return fmt.Errorf("unknown filter %q", f.Name)
+		}
+	}
+	if t.GeneratedProfile != nil {
+		if t.Profile != nil {
+			return errors.New("only one of profile and generated_profile can be set")
+		}
+		err := t.GeneratedProfile.validate()
+		if err != nil {
+			return err
+		}
+	}
+	if t.Provision != nil {
+		err := t.Provision.validate()
+		if err != nil {
+			return err
+		}
+	}
+	if t.Billing != nil {
+		err := t.Billing.validate()
+		if err != nil {
+			return err
+		}
+	}
+	if t.Scopes != nil {
+		err := t.Scopes.validate()
+		if err != nil {
+			return err
+		}
+	}
+	if t.IPAssignment != nil {
+		err := t.IPAssignment.validate(t)
+		if err != nil {
+			return err
+		}
+	}
+	if t.Cookies != nil {
+		err := t.Cookies.validate()
+		if err != nil {
+			return err
+		}
+	}
+	if t.Lifecycle != nil {
+		err := t.Lifecycle.validate()
+		if err != nil {
+			return err
+		}
+	}
+	if t.ConfirmEmail && Conf.MailSink == "" {
+		return errors.New("confirm_email needs -mail-sink")
+	}
+	if t.UsageAlerts && Conf.CrudAlerts == "" {
+		return errors.New("usage_alerts needs -crudalerts")
+	}
+
+	return nil
+}
+
+func (o *ProfileOptions) validate() error {
+	for _, l := range o.Locales {
+		if _, ok := locales[l]; !ok {
+			return fmt.Errorf("unknown locale %q, known locales are %s", l, strings.Join(localeNames(), ", "))
+		}
+	}
+	for _, f := range o.Fields {
+		known := false
+		for _, field := range profileFields {
+			if f == field {
+				known = true
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown profile field %q", f)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	apidadaptor "github.com/sendgrid/chaos/adaptor/apid"
+	"github.com/sendgrid/chaos/client"
+)
+
+func TestTemplateValidate(t *testing.T) {
+	Conf.MailSink = ""
+	tests := []struct {
+		name     string
+		template AccountTemplate
+		err      string
+	}{
+		{"no accounts", AccountTemplate{}, "count must be at least 1"},
+		{"two packages", AccountTemplate{Count: 1, PackageID: 109, PackageUUID: "00000000-0000-0000-0000-000000000109"}, "only one of package_id and package_uuid"},
+		{"negative ips", AccountTemplate{Count: 1, IPs: -1}, "can not be negative"},
+		{"negative credits", AccountTemplate{Count: 1, CreditLimit: &CreditLimit{Credits: -1}}, "credits can not be negative"},
+		{"unknown period", AccountTemplate{Count: 1, CreditLimit: &CreditLimit{Credits: 10, Period: "weekly"}}, "credit limit period"},
+		{"unknown filter", AccountTemplate{Count: 1, Filters: []FilterTemplate{{Name: "nope"}}}, `unknown filter "nope"`},
+		{"two profiles", AccountTemplate{Count: 1, Profile: &client.UserProfile{}, GeneratedProfile: &ProfileOptions{}}, "only one of profile and generated_profile"},
+		{"quoted answer", AccountTemplate{Count: 1, Provision: &ProvisionOptions{Industries: []string{`"Retail"`}}}, "can not contain quotes"},
+		{"unknown strategy", AccountTemplate{Count: 1, IPAssignment: &IPOptions{Strategy: "round_robin"}}, "unknown ip strategy"},
+		{"shared pool ips", AccountTemplate{Count: 1, IPs: 1, IPAssignment: &IPOptions{Strategy: IPStrategySharedPool}}, "can not have ips"},
+		{"too few specific", AccountTemplate{Count: 2, IPs: 1, IPAssignment: &IPOptions{Strategy: IPStrategySpecific, Specific: []string{"10.250.0.2"}}}, "needs count * ips = 2"},
+		{"not an ip", AccountTemplate{Count: 1, IPs: 1, IPAssignment: &IPOptions{Strategy: IPStrategySpecific, Specific: []string{"10.250.0"}}}, "is not an ip"},
+		{"repeated ip", AccountTemplate{Count: 2, IPs: 1, IPAssignment: &IPOptions{Strategy: IPStrategySpecific, Specific: []string{"10.250.0.2", "10.250.0.2"}}}, "more than once"},
+		{"specific without strategy", AccountTemplate{Count: 1, IPs: 1, IPAssignment: &IPOptions{Specific: []string{"10.250.0.2"}}}, "need the specific strategy"},
+		{"unknown malformed", AccountTemplate{Count: 1, Cookies: &CookieOptions{BI: &BICookieOptions{Malformed: "truncated"}}}, "unknown malformed kind"},
+		{"no partners", AccountTemplate{Count: 1, Cookies: &CookieOptions{Partner: &PartnerCookieOptions{}}}, "needs partners"},
+		{"unknown state", AccountTemplate{Count: 1, Lifecycle: &LifecycleOptions{State: "frozen"}}, "unknown lifecycle state"},
+		{"negative downgrade", AccountTemplate{Count: 1, Lifecycle: &LifecycleOptions{State: StateDowngraded, DowngradePackageID: -1}}, "can not be negative"},
+		{"confirm without sink", AccountTemplate{Count: 1, ConfirmEmail: true}, "needs -mail-sink"},
+	}
+
+	for _, test := range tests {
+		err := test.template.validate()
+		if err == nil {
+			t.Errorf("%s: should be rejected", test.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got %q, want it to mention %q", test.name, err.Error(), test.err)
+		}
+	}
+}
+
+func TestTemplateValidateDefaults(t *testing.T) {
+	template := AccountTemplate{
+		Count:       2,
+		IPs:         1,
+		CreditLimit: &CreditLimit{Credits: 100},
+		Lifecycle:   &LifecycleOptions{State: StateDowngraded},
+		IPAssignment: &IPOptions{
+			Strategy: IPStrategySpecific,
+			Specific: []string{"10.250.0.2", "10.250.0.3"},
+		},
+	}
+
+	err := template.validate()
+	if err != nil {
+		t.Fatalf("template should be valid, got %s", err.Error())
+	}
+	if template.PackageID != DefaultPackageID {
+		t.Errorf("got package %d, want %d", template.PackageID, DefaultPackageID)
+	}
+	if template.CreditLimit.Period != apidadaptor.FreeAccountCreditPeriod {
+		t.Errorf("got credit period %s, want %s", template.CreditLimit.Period, apidadaptor.FreeAccountCreditPeriod)
+	}
+	if template.Lifecycle.DowngradePackageID != apidadaptor.FreePackageID {
+		t.Errorf("got downgrade package %d, want %d", template.Lifecycle.DowngradePackageID, apidadaptor.FreePackageID)
+	}
+	if ips := template.IPAssignment.accountIPs(template, 1); len(ips) != 1 || ips[0] != "10.250.0.3" {
+		t.Errorf("got %v for the second account, want 10.250.0.3", ips)
+	}
+}
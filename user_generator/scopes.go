@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/sendgrid/chaos/adaptor/authzd"
+)
+
+// AuthzdAdaptor creates scope sets, it is nil unless -authzd is set
+var AuthzdAdaptor *authzd.Adaptor
+
+// ScopeOptions controls the authzd scope set each account of a template gets.
+// Every account gets one when -authzd is set, from the template for its package unless Template names another
+type ScopeOptions struct {
+	Skip     bool   `json:"skip"`
+	Template string `json:"template"`
+	// RemoveScopes are taken out of the template's scopes, they are checked to be missing afterwards
+	RemoveScopes []string `json:"remove_scopes"`
+	// Require are scopes the scope set must grant
+	Require []string `json:"require"`
+}
+
+// skip reports if the template turns scope sets off, they are on whenever authzd is
+func (o *ScopeOptions) skip() bool {
+	return Conf.Authzd == "" || o != nil && o.Skip
+}
+
+func (o *ScopeOptions) validate() error {
+	if Conf.Authzd == "" && !o.Skip {
+		return errors.New("scopes needs -authzd")
+	}
+	// the adaptor only sends remove_permissions when there is more than one
+	if len(o.RemoveScopes) == 1 {
+		return errors.New("remove_scopes needs at least two scopes")
+	}
+	return nil
+}
+
+// setupAuthzd creates the authzd adaptor
+func setupAuthzd() error {
+	if Conf.Authzd == "" {
+		return nil
+	}
+
+	host, port, err := hostPort(Conf.Authzd)
+	if err != nil {
+		return err
+	}
+
+	AuthzdAdaptor = authzd.New(host, port, Conf.AuthzdHealthcheckPort)
+	return nil
+}
+
+// createScopeSet gives the user the scope set signup would for their package and checks it grants what it should.
+// A resumed run reuses the scope set the user already has
+func createScopeSet(account *Account, options *ScopeOptions, resumed bool) (string, error) {
+	if options == nil {
+		options = &ScopeOptions{}
+	}
+
+	template := options.Template
+	if template == "" {
+		var err error
+		template, err = scopeSetTemplate(account.UserID, account.PackageID)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	var scopeSetID string
+	if resumed {
+		scopeSetID, _ = AuthzdAdaptor.GetUserScopeSetID(account.UserID)
+	}
+	if scopeSetID == "" {
+		var err error
+		scopeSetID, err = AuthzdAdaptor.CreateUserScopeSet(account.UserID, template)
+		if err != nil {
+			return "", fmt.Errorf("unable to create scope set from template %s: %s", template, err.Error())
+		}
+	}
+
+	if len(options.RemoveScopes) > 0 {
+		// the adaptor quotes the scopes in place
+		remove := append([]string{}, options.RemoveScopes...)
+		err := AuthzdAdaptor.SetUserTemplate(account.UserID, template, remove...)
+		if err != nil {
+			return scopeSetID, fmt.Errorf("unable to remove scopes: %s", err.Error())
+		}
+	}
+
+	return scopeSetID, verifyScopeSet(account.UserID, scopeSetID, options)
+}
+
+// scopeSetTemplate names the template for the package, the adaptor panics when apid does not find the package group
+func scopeSetTemplate(userID int, packageID int) (template string, err error) {
+	pkg, adaptorErr := ApidAdaptor.GetPackage(packageID)
+	if adaptorErr != nil {
+		return "", fmt.Errorf("unable to get package %d: %s", packageID, adaptorErr.Error())
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("unable to find the package group of package %d", packageID)
+		}
+	}()
+
+	template, adaptorErr = ApidAdaptor.GenScopeSetTemplateNameFromUserPackage(userID, *pkg)
+	if adaptorErr != nil {
+		return "", fmt.Errorf("unable to name the scope set template of package %d: %s", packageID, adaptorErr.Error())
+	}
+	return template, nil
+}
+
+// verifyScopeSet checks the user has the scope set, that it grants the required scopes and not the removed ones
+func verifyScopeSet(userID int, scopeSetID string, options *ScopeOptions) error {
+	id, err := AuthzdAdaptor.GetUserScopeSetID(userID)
+	if err != nil {
+		return fmt.Errorf("unable to read back scope set: %s", err.Error())
+	}
+	if id != scopeSetID {
+		return fmt.Errorf("user has scope set %s, want %s", id, scopeSetID)
+	}
+
+	if len(options.Require) > 0 {
+		ok, err := AuthzdAdaptor.RequireScopes(scopeSetID, options.Require...)
+		if err != nil {
+			return fmt.Errorf("unable to verify scopes: %s", err.Error())
+		}
+		if !ok {
+			return fmt.Errorf("scope set %s does not grant all of %v", scopeSetID, options.Require)
+		}
+	}
+
+	if len(options.RemoveScopes) > 0 {
+		granted, err := AuthzdAdaptor.VerifyScopes(scopeSetID, options.RemoveScopes...)
+		if err != nil {
+			return fmt.Errorf("unable to verify scopes: %s", err.Error())
+		}
+		// verify matches greedily so only the removed scopes themselves are looked at
+		for _, scope := range options.RemoveScopes {
+			if granted[scope] {
+				return fmt.Errorf("scope set %s still grants removed scope %s", scopeSetID, scope)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	apidadaptor "github.com/sendgrid/chaos/adaptor/apid"
+)
+
+var SteadfastLocationId = 5
+
+// DefaultSeedNetwork is where seeded ips come from, it should never collide with real ips
+const DefaultSeedNetwork = "10.250.0.0/16"
+
+// runSeedIPs makes sure AssignFirstIP has ips to hand out
+func runSeedIPs(args []string) int {
+	fs := flag.NewFlagSet("seed-ips", flag.ExitOnError)
+	count := fs.Int("count", 10, "number of external ips to create")
+	location := fs.Int("location", SteadfastLocationId, "server location the ips live in")
+	network := fs.String("network", DefaultSeedNetwork, "network to take the ips from")
+	registerConfigFlags(fs)
+	err := parseFlags(fs, args)
+	if err != nil {
+		fmt.Printf("oh no there's an error loading the config! %s\n", err.Error())
+		return 2
+	}
+
+	if *count < 1 {
+		fmt.Println("seed-ips needs a -count of at least 1")
+		return 2
+	}
+
+	setupClients()
+
+	ips, err := seedIPs(*count, *location, *network)
+	if err != nil {
+		fmt.Printf("oh no there's an error seeding ips! %s\n", err.Error())
+		if len(ips) > 0 {
+			fmt.Printf("these %d ips were seeded before it: %s\n", len(ips), strings.Join(ips, " "))
+		}
+		return 1
+	}
+
+	available, err := confirmAvailableIPs(ips)
+	if err != nil {
+		fmt.Printf("oh no there's an error confirming the seeded ips! %s\n", err.Error())
+		return 1
+	}
+
+	fmt.Printf("seeded %d ips in location %d, %d of them are available\n", len(ips), *location, available)
+	return 0
+}
+
+// seedIPs creates count unused external ips from network in the location.
+// The process of selecting an ip for the user is as follows:
+// 1. get a list of locations from the ip_assignment_policy table
+// 2. get an ip based from those locations
+// so we need the location, a first_ip policy for it, and a server for the external ips to live on
+func seedIPs(count int, location int, network string) ([]string, error) {
+	_, ipNet, err := net.ParseCIDR(network)
+	if err != nil {
+		return nil, err
+	}
+	if ipNet.IP.To4() == nil {
+		return nil, fmt.Errorf("%s is not an ipv4 network", network)
+	}
+
+	err = ensureServerLocation(location)
+	if err != nil {
+		return nil, fmt.Errorf("unable to add server location %d: %s", location, err.Error())
+	}
+
+	err = ensureFirstIPPolicy(location)
+	if err != nil {
+		return nil, fmt.Errorf("unable to add %s policy for location %d: %s", apidadaptor.FirstIPPolicy, location, err.Error())
+	}
+
+	used, err := usedIPs(ipNet)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get existing ips in %s: %s", network, err.Error())
+	}
+
+	// the first host in the network is the server the external ips live on
+	serverIP := nextIP(ipNet.IP)
+	serverNameID, err := ensureServerName(location, serverIP.String())
+	if err != nil {
+		return nil, fmt.Errorf("unable to add server for location %d: %s", location, err.Error())
+	}
+
+	seeded := make([]string, 0, count)
+	for ip := nextIP(serverIP); ipNet.Contains(ip) && len(seeded) < count; ip = nextIP(ip) {
+		if used[ip.String()] {
+			continue
+		}
+
+		var addExternalIpSuccess int
+		addIPErr := ApidClient.DoFunction("addExternalIp", url.Values{
+			"ip":             []string{ip.String()},
+			"server_name_id": []string{strconv.Itoa(serverNameID)},
+		}, &addExternalIpSuccess)
+		if addIPErr != nil {
+			// someone else took it since we looked
+			if strings.Contains(addIPErr.Error(), "key exists") {
+				continue
+			}
+			return seeded, addIPErr
+		}
+
+		seeded = append(seeded, ip.String())
+	}
+
+	if len(seeded) < count {
+		return seeded, fmt.Errorf("only %d free ips left in %s", len(seeded), network)
+	}
+
+	return seeded, nil
+}
+
+func ensureServerLocation(location int) error {
+	var locations []struct {
+		ID int `json:"id"`
+	}
+	err := ApidClient.DoFunction("executeSql", url.Values{
+		"query":    []string{fmt.Sprintf(`select id from server_location where id = %d`, location)},
+		"rw":       []string{"0"},
+		"resource": []string{"mail"},
+	}, &locations)
+	if err != nil {
+		return err
+	}
+	if len(locations) > 0 {
+		return nil
+	}
+
+	var newServerLocationID int
+	return ApidClient.DoFunction("executeSql", url.Values{
+		"query":    []string{fmt.Sprintf(`insert into server_location (id,name) values(%d,"test")`, location)},
+		"rw":       []string{"1"},
+		"resource": []string{"mail"},
+		"insert":   []string{"1"},
+	}, &newServerLocationID)
+}
+
+func ensureFirstIPPolicy(location int) error {
+	var locations []int
+	err := ApidClient.DoFunction("getAssignmentPolicy", url.Values{
+		"policy": []string{apidadaptor.FirstIPPolicy},
+	}, &locations)
+	if err != nil {
+		return err
+	}
+
+	for _, l := range locations {
+		if l == location {
+			return nil
+		}
+	}
+
+	var setPolicySuccess int
+	return ApidClient.DoFunction("addAssignmentPolicy", url.Values{
+		"policy":   []string{apidadaptor.FirstIPPolicy},
+		"location": []string{strconv.Itoa(location)},
+	}, &setPolicySuccess)
+}
+
+// ensureServerName finds or adds the server seeded ips live on in the location
+func ensureServerName(location int, ip string) (int, error) {
+	server := fmt.Sprintf("usergenerator%d", location)
+
+	var servers []struct {
+		ID int `json:"id"`
+	}
+	err := ApidClient.DoFunction("executeSql", url.Values{
+		"query":    []string{fmt.Sprintf(`select id from server_name where server = "%s" and location = %d`, server, location)},
+		"rw":       []string{"0"},
+		"resource": []string{"mail"},
+	}, &servers)
+	if err != nil {
+		return 0, err
+	}
+	if len(servers) > 0 {
+		return servers[0].ID, nil
+	}
+
+	var serverNameID int
+	err = ApidClient.DoFunction("addServerName", url.Values{
+		"ip":       []string{ip},
+		"server":   []string{server},
+		"type":     []string{"proxy"},
+		"location": []string{strconv.Itoa(location)},
+	}, &serverNameID)
+	if err != nil {
+		return 0, err
+	}
+	if serverNameID == 0 {
+		return 0, errors.New("no server name id returned")
+	}
+
+	return serverNameID, nil
+}
+
+// usedIPs returns the external ips that already exist in the network
+func usedIPs(ipNet *net.IPNet) (map[string]bool, error) {
+	// match on the whole octets of the network, it is parsed so it is safe to use in the query
+	ones, _ := ipNet.Mask.Size()
+	octets := strings.Split(ipNet.IP.String(), ".")[:ones/8]
+	prefix := strings.Join(append(octets, ""), ".")
+
+	var existing []struct {
+		IP string `json:"ip"`
+	}
+	err := ApidClient.DoFunction("executeSql", url.Values{
+		"query":    []string{fmt.Sprintf(`select ip from external_ip where ip like "%s%%"`, prefix)},
+		"rw":       []string{"0"},
+		"resource": []string{"mail"},
+	}, &existing)
+	if err != nil {
+		return nil, err
+	}
+
+	used := make(map[string]bool, len(existing))
+	for _, e := range existing {
+		used[e.IP] = true
+	}
+
+	return used, nil
+}
+
+// confirmAvailableIPs checks every seeded ip is in external_ip without a reseller and returns how many are
+func confirmAvailableIPs(seeded []string) (int, error) {
+	available := 0
+	for _, ip := range seeded {
+		found, err := externalIP(ip)
+		if err != nil {
+			return available, fmt.Errorf("unable to look up %s: %s", ip, err.Error())
+		}
+		if found != nil && found.ResellerID == 0 {
+			available++
+		}
+	}
+
+	if available < len(seeded) {
+		return available, fmt.Errorf("only %d of the %d seeded ips are available", available, len(seeded))
+	}
+
+	return available, nil
+}
+
+// nextIP returns the ipv4 address after ip
+func nextIP(ip net.IP) net.IP {
+	n := binary.BigEndian.Uint32(ip.To4())
+	next := make(net.IP, 4)
+	binary.BigEndian.PutUint32(next, n+1)
+	return next
+}
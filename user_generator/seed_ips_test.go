@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestConfirmAvailableIPs(t *testing.T) {
+	startSimulation(t)
+	seeded := []string{"10.250.1.1", "10.250.1.2"}
+
+	available, err := confirmAvailableIPs(seeded)
+	if err != nil || available != 2 {
+		t.Fatalf("got %d available and %v, want 2 and no error", available, err)
+	}
+
+	adaptorErr := ApidAdaptor.AssignExternalIP(1, seeded[1])
+	if adaptorErr != nil {
+		t.Fatalf("unable to assign %s: %s", seeded[1], adaptorErr.Error())
+	}
+	available, err = confirmAvailableIPs(seeded)
+	if err == nil || available != 1 {
+		t.Errorf("got %d available and %v with one ip taken, want 1 and an error", available, err)
+	}
+
+	available, err = confirmAvailableIPs([]string{"10.251.0.1"})
+	if err == nil || available != 0 {
+		t.Errorf("got %d available and %v for an ip apid does not have, want 0 and an error", available, err)
+	}
+}
+
+func TestNextIP(t *testing.T) {
+	tests := map[string]string{
+		"10.250.0.0":     "10.250.0.1",
+		"10.250.0.255":   "10.250.1.0",
+		"10.255.255.255": "11.0.0.0",
+	}
+
+	for ip, want := range tests {
+		if got := nextIP(net.ParseIP(ip)).String(); got != want {
+			t.Errorf("got %s after %s, want %s", got, ip, want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const simulateSeed = 42
+
+// startSimulation points every client at a fresh set of fakes and seeds the naming
+func startSimulation(t *testing.T) {
+	fs := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	registerConfigFlags(fs)
+	err := parseFlags(fs, []string{"-simulate", "-rps", "0"})
+	if err != nil {
+		t.Fatalf("unable to parse flags: %s", err.Error())
+	}
+	setupClients()
+	startRun(t, simulateSeed)
+}
+
+// simulate generates every account of the scenario one after the other with a journal of its own
+func simulate(t *testing.T, scenario *Scenario) *Manifest {
+	dir, err := ioutil.TempDir("", "user_generator")
+	if err != nil {
+		t.Fatalf("unable to make a temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	RunJournal, err = openJournal(filepath.Join(dir, "manifest.journal"), false)
+	if err != nil {
+		t.Fatalf("unable to open the journal: %s", err.Error())
+	}
+	defer RunJournal.Close()
+
+	manifest := NewManifest()
+	index := 0
+	for _, template := range scenario.Accounts {
+		for i := 0; i < template.Count; i++ {
+			generateAccount(job{template: template, slot: fmt.Sprintf("%s/%d", template.Name, i), index: index, n: i}, manifest)
+			index++
+		}
+	}
+
+	if len(manifest.Accounts) == 0 {
+		t.Fatal("no accounts were generated")
+	}
+	for _, a := range manifest.Accounts {
+		if a.Failed() {
+			t.Errorf("%s account %s failed: %v", a.Template, a.Username, a.Failures)
+		}
+	}
+	return manifest
+}
+
+func TestSimulateDefaultIsReproducible(t *testing.T) {
+	TotalUsers, SubusersPerUser = 3, 2
+
+	startSimulation(t)
+	first := simulate(t, defaultScenario())
+	startSimulation(t)
+	second := simulate(t, defaultScenario())
+
+	if want := TotalUsers * (SubusersPerUser + 1); len(first.Accounts) != want {
+		t.Fatalf("got %d accounts, want %d", len(first.Accounts), want)
+	}
+	if len(first.Accounts) != len(second.Accounts) {
+		t.Fatalf("got %d accounts the second time, want %d", len(second.Accounts), len(first.Accounts))
+	}
+	for i, a := range first.Accounts {
+		b := second.Accounts[i]
+		if a.Username != b.Username || a.Email != b.Email || a.Password != b.Password {
+			t.Errorf("got %s %s the second time, want %s %s", b.Username, b.Email, a.Username, a.Email)
+		}
+	}
+}
+
+func TestSimulateScenario(t *testing.T) {
+	startSimulation(t)
+	scenario, err := loadScenario("scenarios/simulate.json")
+	if err != nil {
+		t.Fatalf("unable to load the scenario: %s", err.Error())
+	}
+
+	simulate(t, scenario)
+}
+
+func TestSimulateSignupBI(t *testing.T) {
+	startSimulation(t)
+	scenario, err := loadScenario("scenarios/simulate.json")
+	if err != nil {
+		t.Fatalf("unable to load the scenario: %s", err.Error())
+	}
+
+	provisioned := make(map[string]bool)
+	for _, template := range scenario.Accounts {
+		provisioned[template.Name] = !template.Provision.skip()
+	}
+
+	for _, a := range simulate(t, scenario).Accounts {
+		if a.ResellerID != 0 || !provisioned[a.Template] {
+			continue
+		}
+		rows, err := getSignupBI(a.UserID)
+		if err != nil {
+			t.Fatalf("unable to read signup bi: %s", err.Error())
+		}
+		if len(rows) != 1 || rows[0].Industry == "" || rows[0].EmailVolume == "" || rows[0].UserPersona == "" {
+			t.Errorf("%s account %s has signup bi %v, want one row with the answers", a.Template, a.Username, rows)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+)
+
+// stages an account goes through, used to name where it failed
+const (
+	StageSignup       = "signup"
+	StageActivate     = "activate"
+	StageIPGroup      = "ip-group"
+	StagePackage      = "package"
+	StageSubscribe    = "subscribe"
+	StageIPAssign     = "ip-assign"
+	StageCreditLimits = "credit-limits"
+	StageProfile      = "profile"
+	StageProvision    = "provision"
+	StageFilters      = "filters"
+	StageBounces      = "bounce-management"
+	StageAlerts       = "usage-alerts"
+	StageScopes       = "scope-set"
+	StageAuth         = "auth"
+	StageDeactivate   = "deactivate"
+	StageSubusers     = "subusers"
+	StageLifecycle    = "lifecycle"
+	StageJournal      = "journal"
+)
+
+// StageError records the stage an account failed in
+type StageError struct {
+	Stage   string `json:"stage"`
+	Message string `json:"error"`
+}
+
+func (e StageError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Stage, e.Message)
+}
+
+// fail records that the account failed in stage
+func (a *Account) fail(stage string, err error) {
+	a.Failures = append(a.Failures, StageError{Stage: stage, Message: err.Error()})
+}
+
+// Failed reports if any stage failed for the account
+func (a *Account) Failed() bool {
+	return len(a.Failures) > 0
+}
+
+// writeSummary writes the per template counts and every failure, it returns the number of failed accounts
+func writeSummary(out io.Writer, manifest *Manifest) int {
+	manifest.mutex.Lock()
+	defer manifest.mutex.Unlock()
+
+	templates := make([]string, 0)
+	created := make(map[string]int)
+	failed := make(map[string]int)
+	totalFailed := 0
+
+	for _, a := range manifest.Accounts {
+		if _, ok := created[a.Template]; !ok {
+			templates = append(templates, a.Template)
+			created[a.Template] = 0
+		}
+		if a.Failed() {
+			failed[a.Template]++
+			totalFailed++
+		} else {
+			created[a.Template]++
+		}
+	}
+
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "TEMPLATE\tCREATED\tFAILED")
+	for _, t := range templates {
+		fmt.Fprintf(w, "%s\t%d\t%d\n", t, created[t], failed[t])
+	}
+	w.Flush()
+
+	if totalFailed == 0 {
+		return 0
+	}
+
+	fmt.Fprintln(out)
+	w = tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "TEMPLATE\tUSER ID\tUSERNAME\tSTAGE\tERROR")
+	for _, a := range manifest.Accounts {
+		userID := "-"
+		if a.UserID != 0 {
+			userID = strconv.Itoa(a.UserID)
+		}
+		for _, f := range a.Failures {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", a.Template, userID, a.Username, f.Stage, f.Message)
+		}
+	}
+	w.Flush()
+
+	return totalFailed
+}
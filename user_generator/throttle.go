@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sendgrid/go-apid"
+)
+
+const (
+	minBackoff = 100 * time.Millisecond
+	maxBackoff = 10 * time.Second
+)
+
+// Throttle limits the rate of requests to chaos and apid and backs off while they are failing
+type Throttle struct {
+	ticker *time.Ticker
+
+	mutex   sync.Mutex
+	backoff time.Duration
+}
+
+// NewThrottle allows rps requests per second, zero or less means unlimited
+func NewThrottle(rps int) *Throttle {
+	t := &Throttle{}
+	if rps > 0 {
+		t.ticker = time.NewTicker(time.Second / time.Duration(rps))
+	}
+	return t
+}
+
+// Wait blocks until the next request is allowed
+func (t *Throttle) Wait() {
+	if t.ticker != nil {
+		<-t.ticker.C
+	}
+
+	t.mutex.Lock()
+	backoff := t.backoff
+	t.mutex.Unlock()
+
+	if backoff > 0 {
+		time.Sleep(backoff)
+	}
+}
+
+// Report doubles the backoff when a request failed and halves it when one succeeded
+func (t *Throttle) Report(failed bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if failed {
+		t.backoff *= 2
+		if t.backoff < minBackoff {
+			t.backoff = minBackoff
+		}
+		if t.backoff > maxBackoff {
+			t.backoff = maxBackoff
+		}
+		return
+	}
+
+	t.backoff /= 2
+	if t.backoff < minBackoff {
+		t.backoff = 0
+	}
+}
+
+// throttledRequester sends every request through the throttle so chaos and apid share one budget
+type throttledRequester struct {
+	throttle *Throttle
+	client   apid.HTTPRequester
+}
+
+func (r *throttledRequester) Do(req *http.Request) (*http.Response, error) {
+	r.throttle.Wait()
+
+	resp, err := r.client.Do(req)
+	failed := err != nil || resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests
+	r.throttle.Report(failed)
+
+	return resp, err
+}
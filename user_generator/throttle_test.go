@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestThrottleBackoff(t *testing.T) {
+	throttle := NewThrottle(0)
+
+	throttle.Report(true)
+	if throttle.backoff != minBackoff {
+		t.Fatalf("got backoff %s after a failure, want %s", throttle.backoff, minBackoff)
+	}
+	throttle.Report(true)
+	if throttle.backoff != 2*minBackoff {
+		t.Errorf("got backoff %s after two failures, want %s", throttle.backoff, 2*minBackoff)
+	}
+
+	for i := 0; i < 20; i++ {
+		throttle.Report(true)
+	}
+	if throttle.backoff != maxBackoff {
+		t.Errorf("got backoff %s after many failures, want %s", throttle.backoff, maxBackoff)
+	}
+
+	throttle.Report(false)
+	if throttle.backoff != maxBackoff/2 {
+		t.Errorf("got backoff %s after a success, want %s", throttle.backoff, maxBackoff/2)
+	}
+	for i := 0; i < 20; i++ {
+		throttle.Report(false)
+	}
+	if throttle.backoff != 0 {
+		t.Errorf("got backoff %s after many successes, want none", throttle.backoff)
+	}
+}
+
+// statusRequester answers every request with the status
+type statusRequester int
+
+func (s statusRequester) Do(req *http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: int(s), Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+}
+
+func TestThrottledRequester(t *testing.T) {
+	tests := []struct {
+		status int
+		failed bool
+	}{
+		{http.StatusOK, false},
+		{http.StatusBadRequest, false},
+		{http.StatusTooManyRequests, true},
+		{http.StatusInternalServerError, true},
+		{http.StatusServiceUnavailable, true},
+	}
+
+	for _, test := range tests {
+		throttle := NewThrottle(0)
+		requester := &throttledRequester{throttle: throttle, client: statusRequester(test.status)}
+		req, _ := http.NewRequest("GET", "http://apid.example.com", nil)
+
+		start := time.Now()
+		_, err := requester.Do(req)
+		if err != nil {
+			t.Fatalf("unable to do the request: %s", err.Error())
+		}
+		if time.Since(start) >= minBackoff {
+			t.Errorf("the first request should not wait, it took %s", time.Since(start))
+		}
+		if failed := throttle.backoff > 0; failed != test.failed {
+			t.Errorf("got backoff %s after a %d, want failed %t", throttle.backoff, test.status, test.failed)
+		}
+	}
+}